@@ -0,0 +1,42 @@
+package entity
+
+// AddressIndex maps each address to the transactions that touch it. It
+// covers the chain blocks whose hashes are in Blocks, in order, and is
+// brought up to date with the chain when it is queried.
+type AddressIndex struct {
+	Blocks  [][32]byte
+	Entries map[string][]AddressEntry
+}
+
+// AddressEntry is transaction Index of the block at Height.
+type AddressEntry struct {
+	Height int
+	Index  int
+}
+
+// AddressTransfer is a transaction as seen from one address: Direction is
+// "in" or "out" and Counterparty is the other side. Pending transfers are
+// still in the transaction pool and have no Height or Timestamp.
+type AddressTransfer struct {
+	ID            [32]byte
+	Direction     string
+	Counterparty  string
+	Amount        float32
+	Height        int
+	Timestamp     int64
+	Confirmations int
+	Pending       bool
+}
+
+// AddressHistory is one page of the confirmed transfers of an address,
+// newest first, and all of its pending ones. Total counts confirmed
+// transactions; a payment to itself is one transaction but two transfers.
+type AddressHistory struct {
+	BlockchainAddress string
+	Transfers         []*AddressTransfer
+	Pending           []*AddressTransfer
+	Offset            int
+	Limit             int
+	Total             int
+	Height            int
+}
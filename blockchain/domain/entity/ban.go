@@ -0,0 +1,7 @@
+package entity
+
+type Ban struct {
+	Address string
+	Until   int64
+	Reason  string
+}
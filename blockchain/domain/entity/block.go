@@ -1,8 +1,122 @@
 package entity
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"go-blockchain/utils"
+)
+
 type Block struct {
 	Timestamp    int64
+	Bits         uint32
 	Nonce        int
 	PreviousHash [32]byte
 	Transactions []*Transaction
+	// Signature seals the block under proof of authority. Like Height it is
+	// not part of the header.
+	Signature *utils.Signature
+	// Height is the block's position in the chain. It is not part of the
+	// header and only set once the block is on a chain.
+	Height int
+}
+
+type blockJSON struct {
+	Hash         string         `json:"hash"`
+	Height       int            `json:"height"`
+	Timestamp    int64          `json:"timestamp"`
+	Bits         uint32         `json:"bits"`
+	Nonce        int            `json:"nonce"`
+	PreviousHash string         `json:"previous_hash"`
+	Transactions []*Transaction `json:"transactions"`
+	Signature    string         `json:"signature,omitempty"`
+}
+
+// Hash is the hash of the canonical header, see utils.EncodeHeader.
+func (b *Block) Hash() [32]byte {
+	return sha256.Sum256(utils.EncodeHeader(b.PreviousHash, TransactionsHash(b.Transactions),
+		b.Timestamp, b.Bits, uint64(b.Nonce)))
+}
+
+// TransactionsHash is the header's commitment to transactions.
+func TransactionsHash(transactions []*Transaction) [32]byte {
+	ids := make([][32]byte, 0, len(transactions))
+	for _, t := range transactions {
+		ids = append(ids, t.ID())
+	}
+	return utils.TransactionsHash(ids)
+}
+
+func (b *Block) MarshalJSON() ([]byte, error) {
+	hash := b.Hash()
+	transactions := b.Transactions
+	if transactions == nil {
+		transactions = []*Transaction{}
+	}
+	v := &blockJSON{
+		Hash:         hex.EncodeToString(hash[:]),
+		Height:       b.Height,
+		Timestamp:    b.Timestamp,
+		Bits:         b.Bits,
+		Nonce:        b.Nonce,
+		PreviousHash: hex.EncodeToString(b.PreviousHash[:]),
+		Transactions: transactions,
+	}
+	if b.Signature != nil {
+		v.Signature = b.Signature.String()
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON rejects a previous_hash that is not 32 bytes of hex, and a
+// hash, when given, that does not match the block.
+func (b *Block) UnmarshalJSON(data []byte) error {
+	var v blockJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	previousHash, err := decodeHash(v.PreviousHash)
+	if err != nil {
+		return fmt.Errorf("previous_hash: %v", err)
+	}
+	*b = Block{
+		Timestamp:    v.Timestamp,
+		Bits:         v.Bits,
+		Nonce:        v.Nonce,
+		PreviousHash: previousHash,
+		Transactions: v.Transactions,
+		Height:       v.Height,
+	}
+	if v.Signature != "" {
+		r, s, err := decodePair(v.Signature)
+		if err != nil {
+			return fmt.Errorf("signature: %v", err)
+		}
+		b.Signature = &utils.Signature{R: r, S: s}
+	}
+	if v.Hash != "" {
+		hash, err := decodeHash(v.Hash)
+		if err != nil {
+			return fmt.Errorf("hash: %v", err)
+		}
+		if hash != b.Hash() {
+			return fmt.Errorf("hash %s does not match the block", v.Hash)
+		}
+	}
+	return nil
+}
+
+func decodeHash(s string) ([32]byte, error) {
+	var h [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return h, err
+	}
+	if len(b) != len(h) {
+		return h, fmt.Errorf("want %d bytes, got %d", len(h), len(b))
+	}
+	copy(h[:], b)
+	return h, nil
 }
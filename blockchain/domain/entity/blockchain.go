@@ -1,13 +1,54 @@
 package entity
 
-import "sync"
+import (
+	"context"
+	"crypto/ecdsa"
+	"sync"
+	"time"
+)
 
 type Blockchain struct {
 	TransactionPool   []*Transaction
 	Chain             []*Block
 	BlockchainAddress string
 	Port              uint16
+	PeerPort          uint16
+	Address           string
+	NetworkID         string
+	GenesisHash       [32]byte
+	Bits              uint32
+	HalvingInterval   int
+	CoinbaseMaturity  int
+	MaxFutureDrift    time.Duration
+	FinalityDepth     int
+	ReorgAlertDepth   int
+	Checkpoints       map[int][32]byte
+	AddressIndex      *AddressIndex
+	DeepReorgs        int
+	RefusedReorgs     int
+	MuxReorgs         sync.Mutex
+	Identity          *ecdsa.PrivateKey
+	RewardKey         *ecdsa.PrivateKey
+	AllowedPeers      map[string]bool
+	RequirePeerCert   bool
 	Mux               sync.Mutex
+	CancelMining      context.CancelFunc
+	ShuttingDown      bool
+	MiningWorkers     int
+	Miner             *Miner
+	MiningTemplates   map[[32]byte]*MiningTemplate
+	Pool              *Pool
+	Hashrate          float64
+	MuxHashrate       sync.Mutex
 	Neighbors         []string
+	Peers             map[string]*Peer
 	MuxNeighbors      sync.Mutex
+	SeenTransactions  map[[32]byte]int64
+	MuxSeen           sync.Mutex
+	SeenPeerRequests  map[string]map[string]int64
+	MuxSeenRequests   sync.Mutex
+	PeerScores        map[string]int
+	Bans              map[string]*Ban
+	BanFile           string
+	MuxBans           sync.Mutex
 }
@@ -1,5 +1,27 @@
 package entity
 
+import "time"
+
 type BlockchainServer struct {
-	Port uint16
+	Port                       uint16
+	PeerPort                   uint16
+	PeerTransport              string
+	NetworkID                  string
+	BanFile                    string
+	IdentityFile               string
+	AllowedPeersFile           string
+	TLSCertFile                string
+	TLSKeyFile                 string
+	TLSCAFile                  string
+	MiningBits                 uint32
+	MiningWorkers              int
+	MiningInterval             time.Duration
+	MineOnStart                bool
+	MiningOnlyWithTransactions bool
+	HalvingInterval            int
+	CoinbaseMaturity           int
+	MaxFutureDrift             time.Duration
+	FinalityDepth              int
+	ReorgAlertDepth            int
+	CheckpointsFile            string
 }
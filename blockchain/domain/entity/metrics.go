@@ -0,0 +1,11 @@
+package entity
+
+type Metrics struct {
+	Height         int
+	CumulativeWork uint64
+	Peers          int
+	Transactions   int
+	Hashrate       float64
+	DeepReorgs     int
+	RefusedReorgs  int
+}
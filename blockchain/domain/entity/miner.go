@@ -0,0 +1,39 @@
+package entity
+
+import (
+	"sync"
+	"time"
+)
+
+type Miner struct {
+	Running              bool
+	Busy                 bool
+	Generation           int
+	Interval             time.Duration
+	OnlyWithTransactions bool
+	LastBlock            *Block
+	LastBlockHeight      int
+	NextRun              int64
+	Timer                *time.Timer
+	Mux                  sync.Mutex
+	MuxRun               sync.Mutex
+}
+
+type MiningStatus struct {
+	State                string
+	Hashrate             float64
+	LastBlock            *Block
+	LastBlockHeight      int
+	NextRun              int64
+	Interval             time.Duration
+	OnlyWithTransactions bool
+}
+
+type MiningTemplate struct {
+	ID           [32]byte
+	Height       int
+	Block        *Block
+	Coinbase     *Transaction
+	HeaderPrefix []byte
+	HeaderSuffix []byte
+}
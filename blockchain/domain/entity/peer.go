@@ -0,0 +1,14 @@
+package entity
+
+// Peer is what a node tells about itself in the version handshake, and what
+// we remember about a neighbor once the handshake succeeded.
+type Peer struct {
+	ProtocolVersion int
+	NetworkID       string
+	GenesisHash     [32]byte
+	Height          int
+	CumulativeWork  uint64
+	Address         string
+	NodeID          string
+	LastSeen        int64
+}
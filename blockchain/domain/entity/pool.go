@@ -0,0 +1,17 @@
+package entity
+
+import "sync"
+
+type PoolWorker struct {
+	Address     string
+	Shares      int
+	TotalShares int
+	LastShare   int64
+	Owed        float32
+	Paid        float32
+}
+
+type Pool struct {
+	Workers map[string]*PoolWorker
+	Mux     sync.Mutex
+}
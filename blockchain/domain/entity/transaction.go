@@ -1,7 +1,100 @@
 package entity
 
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"go-blockchain/utils"
+)
+
 type Transaction struct {
 	SenderBlockchainAddress    string
 	RecipientBlockchainAddress string
 	Value                      float32
+	SenderPublicKey            *ecdsa.PublicKey
+	Signature                  *utils.Signature
+}
+
+type transactionJSON struct {
+	Sender          string  `json:"sender_blockchain_address"`
+	Recipient       string  `json:"recipient_blockchain_address"`
+	Value           float32 `json:"value"`
+	SenderPublicKey string  `json:"sender_public_key,omitempty"`
+	Signature       string  `json:"signature,omitempty"`
+}
+
+// ID identifies a transaction including its signature, see
+// utils.TransactionID. A transaction whose signature is out of range has no
+// ID and gets the zero hash; nodes refuse it, see CheckedID.
+func (t *Transaction) ID() [32]byte {
+	id, _ := t.CheckedID()
+	return id
+}
+
+// CheckedID is ID, failing when the signature is out of range.
+func (t *Transaction) CheckedID() ([32]byte, error) {
+	return utils.TransactionID(t.SenderBlockchainAddress, t.RecipientBlockchainAddress, t.Value, t.Signature)
+}
+
+func (t *Transaction) MarshalJSON() ([]byte, error) {
+	v := &transactionJSON{
+		Sender:    t.SenderBlockchainAddress,
+		Recipient: t.RecipientBlockchainAddress,
+		Value:     t.Value,
+	}
+	if t.SenderPublicKey != nil {
+		v.SenderPublicKey = fmt.Sprintf("%064x%064x", t.SenderPublicKey.X, t.SenderPublicKey.Y)
+	}
+	if t.Signature != nil {
+		v.Signature = t.Signature.String()
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON accepts the sender's public key and the signature as 64
+// bytes of hex each, or leaves them nil when they are missing.
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	var v transactionJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*t = Transaction{
+		SenderBlockchainAddress:    v.Sender,
+		RecipientBlockchainAddress: v.Recipient,
+		Value:                      v.Value,
+	}
+	if v.SenderPublicKey != "" {
+		x, y, err := decodePair(v.SenderPublicKey)
+		if err != nil {
+			return fmt.Errorf("sender_public_key: %v", err)
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return fmt.Errorf("sender_public_key: not a P-256 point")
+		}
+		t.SenderPublicKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	}
+	if v.Signature != "" {
+		r, s, err := decodePair(v.Signature)
+		if err != nil {
+			return fmt.Errorf("signature: %v", err)
+		}
+		t.Signature = &utils.Signature{R: r, S: s}
+	}
+	return nil
+}
+
+// decodePair splits 64 bytes of hex into two 32 byte numbers.
+func decodePair(s string) (*big.Int, *big.Int, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(b) != 64 {
+		return nil, nil, fmt.Errorf("want 64 bytes, got %d", len(b))
+	}
+	return new(big.Int).SetBytes(b[:32]), new(big.Int).SetBytes(b[32:]), nil
 }
@@ -8,6 +8,4 @@ type BlockRepository interface {
 	Transactions(b *entity.Block) []*entity.Transaction
 	Print(b *entity.Block, tr TransactionRepository)
 	Hash(b *entity.Block) [32]byte
-	MarshalJSON(b *entity.Block) ([]byte, error)
-	UnmarshalJSON(b *entity.Block, data []byte) error
 }
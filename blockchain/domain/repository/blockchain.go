@@ -9,12 +9,24 @@ import (
 
 type BlockchainRepository interface {
 	Chain(bc *entity.Blockchain) []*entity.Block
+	Consensus() ConsensusEngine
 	Run(bc *entity.Blockchain, br BlockRepository)
 	SetNeighbors(bc *entity.Blockchain)
 	SyncNeighbors(bc *entity.Blockchain)
 	StartSyncNeighbors(bc *entity.Blockchain)
+	LocalPeer(bc *entity.Blockchain) *entity.Peer
+	AcceptHandshake(bc *entity.Blockchain, remote *entity.Peer) (*entity.Peer, bool)
+	Peers(bc *entity.Blockchain) []*entity.Peer
+	CumulativeWork(chain []*entity.Block) uint64
+	Misbehaving(bc *entity.Blockchain, peer string, score int, reason string)
+	IsBanned(bc *entity.Blockchain, peer string) bool
+	Bans(bc *entity.Blockchain) []*entity.Ban
+	ClearBans(bc *entity.Blockchain, address string)
+	LoadBans(bc *entity.Blockchain)
+	IsAllowedPeer(bc *entity.Blockchain, nodeID string) bool
 	TransactionPool(bc *entity.Blockchain) []*entity.Transaction
 	ClearTransactionPool(bc *entity.Blockchain)
+	RemoveBlockTransactions(bc *entity.Blockchain, b *entity.Block) bool
 	MarshalJSON(bc *entity.Blockchain) ([]byte, error)
 	UnmarshalJSON(bc *entity.Blockchain, data []byte) error
 	CreateBlock(bc *entity.Blockchain, nonce int, previousHash [32]byte) *entity.Block
@@ -24,14 +36,26 @@ type BlockchainRepository interface {
 		senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool
 	AddTransaction(bc *entity.Blockchain, sender string, recipient string, value float32,
 		senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool
+	ReceiveTransaction(bc *entity.Blockchain, peer string, sender string, recipient string, value float32,
+		senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool
+	MissingTransactions(bc *entity.Blockchain, hashes [][32]byte) [][32]byte
+	RelayTransaction(bc *entity.Blockchain, t *entity.Transaction)
 	VerifyTransactionSignature(bc *entity.Blockchain,
 		senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *entity.Transaction) bool
 	CopyTransactionPool(bc *entity.Blockchain) []*entity.Transaction
-	ValidProof(bc *entity.Blockchain, br BlockRepository, nonce int, previousHash [32]byte, transactions []*entity.Transaction, difficulty int) bool
-	ProofOfWork(bc *entity.Blockchain, br BlockRepository) int
 	Mining(bc *entity.Blockchain, br BlockRepository) bool
-	StartMining(bc *entity.Blockchain, br BlockRepository)
+	StartMining(bc *entity.Blockchain, br BlockRepository) bool
+	StopMining(bc *entity.Blockchain) bool
+	MiningStatus(bc *entity.Blockchain) *entity.MiningStatus
+	MiningTemplate(bc *entity.Blockchain, br BlockRepository, rewardAddress string) *entity.MiningTemplate
+	SubmitBlock(bc *entity.Blockchain, br BlockRepository, id [32]byte, nonce int) bool
+	Pay(bc *entity.Blockchain, recipient string, value float32) bool
+	PoolWorkers(bc *entity.Blockchain) ([]*entity.PoolWorker, bool)
+	Shutdown(bc *entity.Blockchain)
 	CalculateTotalAmount(bc *entity.Blockchain, blockchainAddress string) float32
+	SpendableAmount(bc *entity.Blockchain, blockchainAddress string) float32
+	AddressHistory(bc *entity.Blockchain, blockchainAddress string, offset int, limit int) *entity.AddressHistory
 	ValidChain(bc *entity.Blockchain, br BlockRepository, chain []*entity.Block) bool
 	ResolveConflicts(bc *entity.Blockchain, br BlockRepository) bool
+	Metrics(bc *entity.Blockchain) *entity.Metrics
 }
@@ -12,9 +12,22 @@ type BlockchainServerRepository interface {
 	GetBlockchain(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository) *entity.Blockchain
 	GetChain(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
 	Transactions(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
+	PeerTransactions(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
+	Inventory(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
+	Handshake(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
+	AdminPeers(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
+	AdminBans(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
 	Mine(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
 	StartMine(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
+	StopMine(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
+	MineStatus(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
+	MiningTemplate(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
+	MiningSubmit(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
+	PoolWorkers(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
+	Metrics(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
 	Amount(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
+	History(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
+	PeerChain(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
 	Consensus(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request)
 	Run(bs *entity.BlockchainServer, bcr BlockchainRepository, br BlockRepository, wr repository.WalletRepository)
 }
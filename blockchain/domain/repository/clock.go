@@ -0,0 +1,10 @@
+package repository
+
+import "time"
+
+// Clock is the time blocks are stamped with and checked against. Nodes use
+// the system clock; a different Clock lets timestamp rules be exercised
+// without waiting.
+type Clock interface {
+	Now() time.Time
+}
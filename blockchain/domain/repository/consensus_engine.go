@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"context"
+
+	"go-blockchain/blockchain/domain/entity"
+)
+
+// ConsensusEngine decides who may extend the chain and how. It seals the
+// blocks this node builds, verifies the ones peers send and weighs chains
+// for fork choice: the valid chain with the greatest Weight wins.
+// Prepare returns false when this node may not seal a block on parent.
+// Seal blocks until b is sealed or ctx is cancelled. b.Height is set
+// before Prepare and VerifySeal are called.
+type ConsensusEngine interface {
+	Name() string
+	Genesis(bc *entity.Blockchain) *entity.Block
+	Prepare(bc *entity.Blockchain, parent *entity.Block, b *entity.Block) bool
+	Seal(ctx context.Context, bc *entity.Blockchain, b *entity.Block) bool
+	VerifySeal(bc *entity.Blockchain, parent *entity.Block, b *entity.Block) bool
+	Weight(chain []*entity.Block) uint64
+}
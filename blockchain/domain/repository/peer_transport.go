@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"crypto/ecdsa"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/utils"
+)
+
+// PeerTransport is how a node talks to its neighbors. bc is always the local
+// node and peer is the neighbor's address as stored in bc.Neighbors.
+// AnnounceTransactions returns the hashes the peer does not have yet, or
+// the error that kept it from answering.
+type PeerTransport interface {
+	Handshake(bc *entity.Blockchain, peer string, local *entity.Peer) (*entity.Peer, bool)
+	AnnounceBlock(bc *entity.Blockchain, peer string, b *entity.Block) bool
+	AnnounceTransactions(bc *entity.Blockchain, peer string, hashes [][32]byte) ([][32]byte, error)
+	SendTransaction(bc *entity.Blockchain, peer string, t *entity.Transaction,
+		senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool
+	RequestChain(bc *entity.Blockchain, peer string) ([]*entity.Block, bool)
+	TriggerConsensus(bc *entity.Blockchain, peer string) bool
+}
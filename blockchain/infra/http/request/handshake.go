@@ -0,0 +1,23 @@
+package request
+
+type HandshakeRequest struct {
+	ProtocolVersion *int    `json:"protocol_version"`
+	NetworkID       *string `json:"network_id"`
+	GenesisHash     *string `json:"genesis_hash"`
+	Height          *int    `json:"height"`
+	CumulativeWork  *uint64 `json:"cumulative_work"`
+	Address         *string `json:"address"`
+	NodeID          *string `json:"node_id"`
+}
+
+func (hr *HandshakeRequest) Validate() bool {
+	if hr.ProtocolVersion == nil ||
+		hr.NetworkID == nil ||
+		hr.GenesisHash == nil ||
+		hr.Height == nil ||
+		hr.CumulativeWork == nil ||
+		hr.Address == nil {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,9 @@
+package request
+
+type InventoryRequest struct {
+	Transactions *[]string `json:"transactions"`
+}
+
+func (ir *InventoryRequest) Validate() bool {
+	return ir.Transactions != nil
+}
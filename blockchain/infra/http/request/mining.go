@@ -0,0 +1,10 @@
+package request
+
+type MiningSubmitRequest struct {
+	TemplateID *string `json:"template_id"`
+	Nonce      *int    `json:"nonce"`
+}
+
+func (mr *MiningSubmitRequest) Validate() bool {
+	return mr.TemplateID != nil && mr.Nonce != nil
+}
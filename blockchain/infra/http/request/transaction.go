@@ -16,5 +16,6 @@ func (tr *TransactionRequest) Validate() bool {
 		tr.Signature == nil {
 		return false
 	}
-	return true
+	// utils.PublicKeyFromString splits the key at 64 hex digits.
+	return len(*tr.SenderPublicKey) == 128
 }
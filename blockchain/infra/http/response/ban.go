@@ -0,0 +1,12 @@
+package response
+
+type BanResponse struct {
+	Address string `json:"address"`
+	Until   int64  `json:"until"`
+	Reason  string `json:"reason"`
+}
+
+type BansResponse struct {
+	Bans   []*BanResponse `json:"bans"`
+	Length int            `json:"length"`
+}
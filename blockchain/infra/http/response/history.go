@@ -0,0 +1,24 @@
+package response
+
+type TransferResponse struct {
+	ID            string  `json:"id"`
+	Direction     string  `json:"direction"`
+	Counterparty  string  `json:"counterparty"`
+	Amount        float32 `json:"amount"`
+	Height        int     `json:"height,omitempty"`
+	Timestamp     int64   `json:"timestamp,omitempty"`
+	Confirmations int     `json:"confirmations"`
+	Pending       bool    `json:"pending"`
+}
+
+// HistoryResponse is one page of an address's confirmed transfers, newest
+// first, and its pending ones. Offset and Total count transactions.
+type HistoryResponse struct {
+	BlockchainAddress string              `json:"blockchain_address"`
+	Transfers         []*TransferResponse `json:"transfers"`
+	Pending           []*TransferResponse `json:"pending"`
+	Offset            int                 `json:"offset"`
+	Limit             int                 `json:"limit"`
+	Total             int                 `json:"total"`
+	Height            int                 `json:"height"`
+}
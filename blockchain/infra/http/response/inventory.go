@@ -0,0 +1,5 @@
+package response
+
+type InventoryResponse struct {
+	Transactions []string `json:"transactions"`
+}
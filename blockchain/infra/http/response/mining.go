@@ -0,0 +1,53 @@
+package response
+
+type MinedBlockResponse struct {
+	Hash      string `json:"hash"`
+	Height    int    `json:"height"`
+	Timestamp int64  `json:"timestamp"`
+	Nonce     int    `json:"nonce"`
+}
+
+type MiningStatusResponse struct {
+	State                string              `json:"state"`
+	Hashrate             float64             `json:"hashrate"`
+	LastBlock            *MinedBlockResponse `json:"last_block"`
+	NextRun              int64               `json:"next_run,omitempty"`
+	IntervalSec          float64             `json:"interval_sec"`
+	OnlyWithTransactions bool                `json:"only_with_transactions"`
+}
+
+type TransactionResponse struct {
+	SenderBlockchainAddress    string  `json:"sender_blockchain_address"`
+	RecipientBlockchainAddress string  `json:"recipient_blockchain_address"`
+	Value                      float32 `json:"value"`
+}
+
+// MiningTemplateResponse is work for an external miner. A nonce solves it
+// when sha256(header_prefix || nonce || header_suffix), with the nonce as 8
+// bytes big endian, is at most target. Transactions end with the coinbase.
+type MiningTemplateResponse struct {
+	TemplateID   string                 `json:"template_id"`
+	Height       int                    `json:"height"`
+	PreviousHash string                 `json:"previous_hash"`
+	Timestamp    int64                  `json:"timestamp"`
+	Bits         string                 `json:"bits"`
+	Target       string                 `json:"target"`
+	Transactions []*TransactionResponse `json:"transactions"`
+	Coinbase     *TransactionResponse   `json:"coinbase"`
+	HeaderPrefix string                 `json:"header_prefix"`
+	HeaderSuffix string                 `json:"header_suffix"`
+}
+
+type PoolWorkerResponse struct {
+	Address     string  `json:"address"`
+	Shares      int     `json:"shares"`
+	TotalShares int     `json:"total_shares"`
+	LastShare   int64   `json:"last_share"`
+	Owed        float32 `json:"owed"`
+	Paid        float32 `json:"paid"`
+}
+
+type PoolWorkersResponse struct {
+	Workers []*PoolWorkerResponse `json:"workers"`
+	Length  int                   `json:"length"`
+}
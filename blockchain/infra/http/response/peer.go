@@ -0,0 +1,17 @@
+package response
+
+type PeerResponse struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	NetworkID       string `json:"network_id"`
+	GenesisHash     string `json:"genesis_hash"`
+	Height          int    `json:"height"`
+	CumulativeWork  uint64 `json:"cumulative_work"`
+	Address         string `json:"address"`
+	NodeID          string `json:"node_id"`
+	LastSeen        int64  `json:"last_seen,omitempty"`
+}
+
+type PeersResponse struct {
+	Peers  []*PeerResponse `json:"peers"`
+	Length int             `json:"length"`
+}
@@ -0,0 +1,271 @@
+package p2p
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/big"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/utils"
+)
+
+var ErrShortPayload = errors.New("p2p: payload too short")
+var ErrTrailingBytes = errors.New("p2p: trailing bytes in payload")
+
+type Encoder struct {
+	buf []byte
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{buf: make([]byte, 0, 64)}
+}
+
+func (e *Encoder) Data() []byte {
+	return e.buf
+}
+
+func (e *Encoder) Uint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *Encoder) Uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *Encoder) Uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *Encoder) Uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	e.buf = append(e.buf, b[:n]...)
+}
+
+func (e *Encoder) Bytes32(v [32]byte) {
+	e.buf = append(e.buf, v[:]...)
+}
+
+func (e *Encoder) Raw(v []byte) {
+	e.buf = append(e.buf, v...)
+}
+
+func (e *Encoder) String(v string) {
+	e.Uvarint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// Decoder reads what Encoder wrote. The first error sticks and every later
+// read returns zero values, so callers only check Finish.
+type Decoder struct {
+	data []byte
+	err  error
+}
+
+func NewDecoder(data []byte) *Decoder {
+	return &Decoder{data: data}
+}
+
+func (d *Decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.data) < n {
+		d.err = ErrShortPayload
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *Decoder) Uint8() uint8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *Decoder) Uint32() uint32 {
+	b := d.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *Decoder) Uint64() uint64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *Decoder) Uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = ErrShortPayload
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// Count reads an element count and checks it against the bytes left, given
+// the smallest encoded size of one element.
+func (d *Decoder) Count(minSize int) int {
+	n := d.Uvarint()
+	if d.err == nil && n > uint64(len(d.data)/minSize) {
+		d.err = ErrShortPayload
+		return 0
+	}
+	return int(n)
+}
+
+func (d *Decoder) Bytes32() [32]byte {
+	var v [32]byte
+	copy(v[:], d.take(32))
+	return v
+}
+
+func (d *Decoder) Raw(n int) []byte {
+	return d.take(n)
+}
+
+func (d *Decoder) String() string {
+	n := d.Count(1)
+	return string(d.take(n))
+}
+
+func (d *Decoder) Finish() error {
+	if d.err == nil && len(d.data) != 0 {
+		return ErrTrailingBytes
+	}
+	return d.err
+}
+
+func point64(x *big.Int, y *big.Int) []byte {
+	b := make([]byte, 64)
+	x.FillBytes(b[:32])
+	y.FillBytes(b[32:])
+	return b
+}
+
+func encodeTransaction(e *Encoder, t *entity.Transaction) {
+	e.String(t.SenderBlockchainAddress)
+	e.String(t.RecipientBlockchainAddress)
+	e.Uint32(math.Float32bits(t.Value))
+	if t.SenderPublicKey == nil || t.Signature == nil {
+		e.Uint8(0)
+		return
+	}
+	e.Uint8(1)
+	e.Raw(point64(t.SenderPublicKey.X, t.SenderPublicKey.Y))
+	e.Raw(point64(t.Signature.R, t.Signature.S))
+}
+
+func decodeTransaction(d *Decoder) *entity.Transaction {
+	t := new(entity.Transaction)
+	t.SenderBlockchainAddress = d.String()
+	t.RecipientBlockchainAddress = d.String()
+	t.Value = math.Float32frombits(d.Uint32())
+	if d.Uint8() == 1 {
+		key := d.Raw(64)
+		sig := d.Raw(64)
+		if key != nil && sig != nil {
+			t.SenderPublicKey = &ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(key[:32]),
+				Y:     new(big.Int).SetBytes(key[32:]),
+			}
+			t.Signature = &utils.Signature{
+				R: new(big.Int).SetBytes(sig[:32]),
+				S: new(big.Int).SetBytes(sig[32:]),
+			}
+		}
+	}
+	return t
+}
+
+func EncodeTransaction(t *entity.Transaction) []byte {
+	e := NewEncoder()
+	encodeTransaction(e, t)
+	return e.Data()
+}
+
+func DecodeTransaction(data []byte) (*entity.Transaction, error) {
+	d := NewDecoder(data)
+	t := decodeTransaction(d)
+	return t, d.Finish()
+}
+
+func encodeBlock(e *Encoder, b *entity.Block) {
+	e.Uint64(uint64(b.Timestamp))
+	e.Uint32(b.Bits)
+	e.Uint64(uint64(b.Nonce))
+	e.Bytes32(b.PreviousHash)
+	e.Uvarint(uint64(len(b.Transactions)))
+	for _, t := range b.Transactions {
+		encodeTransaction(e, t)
+	}
+	if b.Signature == nil {
+		e.Uint8(0)
+		return
+	}
+	e.Uint8(1)
+	e.Raw(point64(b.Signature.R, b.Signature.S))
+}
+
+func decodeBlock(d *Decoder) *entity.Block {
+	b := new(entity.Block)
+	b.Timestamp = int64(d.Uint64())
+	b.Bits = d.Uint32()
+	b.Nonce = int(d.Uint64())
+	b.PreviousHash = d.Bytes32()
+	n := d.Count(7)
+	b.Transactions = make([]*entity.Transaction, 0, n)
+	for i := 0; i < n; i++ {
+		b.Transactions = append(b.Transactions, decodeTransaction(d))
+	}
+	if d.Uint8() == 1 {
+		if sig := d.Raw(64); sig != nil {
+			b.Signature = &utils.Signature{
+				R: new(big.Int).SetBytes(sig[:32]),
+				S: new(big.Int).SetBytes(sig[32:]),
+			}
+		}
+	}
+	return b
+}
+
+// EncodeBlocks is the body of a block message: a count and the blocks.
+func EncodeBlocks(blocks []*entity.Block) []byte {
+	e := NewEncoder()
+	e.Uvarint(uint64(len(blocks)))
+	for _, b := range blocks {
+		encodeBlock(e, b)
+	}
+	return e.Data()
+}
+
+func DecodeBlocks(data []byte) ([]*entity.Block, error) {
+	d := NewDecoder(data)
+	n := d.Count(54)
+	blocks := make([]*entity.Block, 0, n)
+	for i := 0; i < n; i++ {
+		blocks = append(blocks, decodeBlock(d))
+	}
+	return blocks, d.Finish()
+}
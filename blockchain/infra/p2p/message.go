@@ -0,0 +1,175 @@
+package p2p
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Every frame on the wire is
+//
+//	magic   uint32
+//	type    uint8
+//	length  uint32
+//	payload [length]byte
+//
+// all big endian. Requests sent by the dialing node carry a signature in
+// front of the body, see SignedPayload. Responses carry the bare body.
+
+const (
+	MAGIC            uint32 = 0x0b1c0c4a
+	MAX_PAYLOAD_SIZE        = 32 << 20
+	// Until a peer has passed the handshake it may only send frames of up
+	// to MAX_HANDSHAKE_SIZE bytes.
+	MAX_HANDSHAKE_SIZE = 4 << 10
+	HEADER_SIZE        = 9
+)
+
+const (
+	MsgHandshake uint8 = 0x01
+	MsgInv       uint8 = 0x02
+	MsgGetBlocks uint8 = 0x03
+	MsgBlock     uint8 = 0x04
+	MsgTx        uint8 = 0x05
+	MsgPing      uint8 = 0x06
+	MsgPong      uint8 = 0x07
+	MsgAck       uint8 = 0x08
+	MsgReject    uint8 = 0x09
+)
+
+const (
+	InvTransaction uint8 = 0x01
+	InvBlock       uint8 = 0x02
+)
+
+var ErrBadMagic = errors.New("p2p: bad magic")
+
+type Frame struct {
+	Type    uint8
+	Payload []byte
+}
+
+func WriteFrame(w io.Writer, f *Frame) error {
+	header := make([]byte, HEADER_SIZE)
+	binary.BigEndian.PutUint32(header[0:4], MAGIC)
+	header[4] = f.Type
+	binary.BigEndian.PutUint32(header[5:9], uint32(len(f.Payload)))
+	if _, err := w.Write(append(header, f.Payload...)); err != nil {
+		return err
+	}
+	if bw, ok := w.(*bufio.Writer); ok {
+		return bw.Flush()
+	}
+	return nil
+}
+
+func ReadFrame(r io.Reader) (*Frame, error) {
+	return ReadFrameLimit(r, MAX_PAYLOAD_SIZE)
+}
+
+// ReadFrameLimit is ReadFrame for payloads of at most limit bytes.
+func ReadFrameLimit(r io.Reader, limit uint32) (*Frame, error) {
+	header := make([]byte, HEADER_SIZE)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(header[0:4]) != MAGIC {
+		return nil, ErrBadMagic
+	}
+	length := binary.BigEndian.Uint32(header[5:9])
+	if length > limit {
+		return nil, fmt.Errorf("p2p: payload of %d bytes is too large", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return &Frame{Type: header[4], Payload: payload}, nil
+}
+
+// Handshake is the first request on every connection.
+type Handshake struct {
+	ProtocolVersion uint32
+	NetworkID       string
+	GenesisHash     [32]byte
+	Height          uint64
+	CumulativeWork  uint64
+	Address         string
+	NodeID          [64]byte
+}
+
+func (h *Handshake) Encode() []byte {
+	e := NewEncoder()
+	e.Uint32(h.ProtocolVersion)
+	e.String(h.NetworkID)
+	e.Bytes32(h.GenesisHash)
+	e.Uint64(h.Height)
+	e.Uint64(h.CumulativeWork)
+	e.String(h.Address)
+	e.Raw(h.NodeID[:])
+	return e.Data()
+}
+
+func DecodeHandshake(data []byte) (*Handshake, error) {
+	d := NewDecoder(data)
+	h := new(Handshake)
+	h.ProtocolVersion = d.Uint32()
+	h.NetworkID = d.String()
+	h.GenesisHash = d.Bytes32()
+	h.Height = d.Uint64()
+	h.CumulativeWork = d.Uint64()
+	h.Address = d.String()
+	copy(h.NodeID[:], d.Raw(64))
+	return h, d.Finish()
+}
+
+type InvEntry struct {
+	Type uint8
+	Hash [32]byte
+}
+
+func EncodeInv(entries []*InvEntry) []byte {
+	e := NewEncoder()
+	e.Uvarint(uint64(len(entries)))
+	for _, entry := range entries {
+		e.Uint8(entry.Type)
+		e.Bytes32(entry.Hash)
+	}
+	return e.Data()
+}
+
+func DecodeInv(data []byte) ([]*InvEntry, error) {
+	d := NewDecoder(data)
+	n := d.Count(33)
+	entries := make([]*InvEntry, 0, n)
+	for i := 0; i < n; i++ {
+		entries = append(entries, &InvEntry{Type: d.Uint8(), Hash: d.Bytes32()})
+	}
+	return entries, d.Finish()
+}
+
+func EncodeGetBlocks(fromHeight uint64) []byte {
+	e := NewEncoder()
+	e.Uint64(fromHeight)
+	return e.Data()
+}
+
+func DecodeGetBlocks(data []byte) (uint64, error) {
+	d := NewDecoder(data)
+	from := d.Uint64()
+	return from, d.Finish()
+}
+
+func EncodeNonce(nonce uint64) []byte {
+	e := NewEncoder()
+	e.Uint64(nonce)
+	return e.Data()
+}
+
+func DecodeNonce(data []byte) (uint64, error) {
+	d := NewDecoder(data)
+	nonce := d.Uint64()
+	return nonce, d.Finish()
+}
@@ -0,0 +1,74 @@
+package p2p
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+	"time"
+)
+
+// A signed payload is
+//
+//	timestamp int64 (unix seconds)
+//	signature [64]byte (r || s)
+//	body
+//
+// where the signature covers sha256(type || timestamp || body).
+
+const SIGNATURE_OVERHEAD = 8 + 64
+
+func signedDigest(msgType uint8, timestamp uint64, body []byte) []byte {
+	e := NewEncoder()
+	e.Uint8(msgType)
+	e.Uint64(timestamp)
+	e.Raw(body)
+	h := sha256.Sum256(e.Data())
+	return h[:]
+}
+
+func SignPayload(key *ecdsa.PrivateKey, msgType uint8, body []byte) ([]byte, error) {
+	timestamp := uint64(time.Now().Unix())
+	r, s, err := ecdsa.Sign(rand.Reader, key, signedDigest(msgType, timestamp, body))
+	if err != nil {
+		return nil, err
+	}
+	e := NewEncoder()
+	e.Uint64(timestamp)
+	e.Raw(point64(r, s))
+	e.Raw(body)
+	return e.Data(), nil
+}
+
+// OpenPayload checks the signature against nodeID (the sender's public key
+// as x || y) and that the timestamp is within maxAge of now, and returns the
+// body.
+func OpenPayload(nodeID [64]byte, msgType uint8, payload []byte, maxAge int64) ([]byte, bool) {
+	if len(payload) < SIGNATURE_OVERHEAD {
+		return nil, false
+	}
+	d := NewDecoder(payload)
+	timestamp := d.Uint64()
+	sig := d.Raw(64)
+	body := payload[SIGNATURE_OVERHEAD:]
+
+	age := time.Now().Unix() - int64(timestamp)
+	if age > maxAge || age < -maxAge {
+		return nil, false
+	}
+	publicKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(nodeID[:32]),
+		Y:     new(big.Int).SetBytes(nodeID[32:]),
+	}
+	if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, false
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(publicKey, signedDigest(msgType, timestamp, body), r, s) {
+		return nil, false
+	}
+	return body, true
+}
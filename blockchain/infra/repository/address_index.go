@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"go-blockchain/blockchain/domain/entity"
+)
+
+const (
+	HISTORY_PAGE_SIZE     = 50
+	HISTORY_MAX_PAGE_SIZE = 500
+)
+
+// addresses are the addresses t touches, once each.
+func addresses(t *entity.Transaction) []string {
+	if t.SenderBlockchainAddress == t.RecipientBlockchainAddress {
+		return []string{t.SenderBlockchainAddress}
+	}
+	return []string{t.SenderBlockchainAddress, t.RecipientBlockchainAddress}
+}
+
+// syncAddressIndex brings bc.AddressIndex up to date with bc.Chain. Blocks
+// a reorg dropped are unindexed from the top down, then the new ones are
+// added, so a query normally only hashes the tip. It must be called with
+// bc.Mux held.
+func (bcr *blockchainRepository) syncAddressIndex(bc *entity.Blockchain) *entity.AddressIndex {
+	idx := bc.AddressIndex
+	if idx == nil {
+		idx = &entity.AddressIndex{Entries: make(map[string][]entity.AddressEntry)}
+		bc.AddressIndex = idx
+	}
+	for n := len(idx.Blocks); n > 0; n-- {
+		if n <= len(bc.Chain) && bc.Chain[n-1].Hash() == idx.Blocks[n-1] {
+			break
+		}
+		// Entries are appended in chain order, so the dropped block's are
+		// at the end of each list.
+		for a, entries := range idx.Entries {
+			i := len(entries)
+			for i > 0 && entries[i-1].Height >= n-1 {
+				i--
+			}
+			if i == 0 {
+				delete(idx.Entries, a)
+			} else {
+				idx.Entries[a] = entries[:i]
+			}
+		}
+		idx.Blocks = idx.Blocks[:n-1]
+	}
+	for height := len(idx.Blocks); height < len(bc.Chain); height++ {
+		b := bc.Chain[height]
+		for i, t := range b.Transactions {
+			for _, a := range addresses(t) {
+				idx.Entries[a] = append(idx.Entries[a], entity.AddressEntry{Height: height, Index: i})
+			}
+		}
+		idx.Blocks = append(idx.Blocks, b.Hash())
+	}
+	return idx
+}
+
+// addressTransfers lists what address sent and received in t. A payment
+// to itself is both.
+func addressTransfers(address string, t *entity.Transaction) []*entity.AddressTransfer {
+	transfers := make([]*entity.AddressTransfer, 0, 2)
+	if t.SenderBlockchainAddress == address {
+		transfers = append(transfers, &entity.AddressTransfer{
+			ID: t.ID(), Direction: "out", Counterparty: t.RecipientBlockchainAddress, Amount: t.Value})
+	}
+	if t.RecipientBlockchainAddress == address {
+		transfers = append(transfers, &entity.AddressTransfer{
+			ID: t.ID(), Direction: "in", Counterparty: t.SenderBlockchainAddress, Amount: t.Value})
+	}
+	return transfers
+}
+
+// AddressHistory pages through the confirmed transactions of address,
+// newest first, skipping offset of them and returning at most limit, and
+// lists its transactions still in the pool.
+func (bcr *blockchainRepository) AddressHistory(bc *entity.Blockchain, blockchainAddress string, offset int, limit int) *entity.AddressHistory {
+	if limit <= 0 {
+		limit = HISTORY_PAGE_SIZE
+	}
+	if limit > HISTORY_MAX_PAGE_SIZE {
+		limit = HISTORY_MAX_PAGE_SIZE
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	bc.Mux.Lock()
+	defer bc.Mux.Unlock()
+	idx := bcr.syncAddressIndex(bc)
+	entries := idx.Entries[blockchainAddress]
+	h := &entity.AddressHistory{
+		BlockchainAddress: blockchainAddress,
+		Transfers:         make([]*entity.AddressTransfer, 0),
+		Pending:           make([]*entity.AddressTransfer, 0),
+		Offset:            offset,
+		Limit:             limit,
+		Total:             len(entries),
+		Height:            len(bc.Chain) - 1,
+	}
+	for i := len(entries) - 1 - offset; i >= 0 && len(h.Transfers) < limit; i-- {
+		b := bc.Chain[entries[i].Height]
+		for _, t := range addressTransfers(blockchainAddress, b.Transactions[entries[i].Index]) {
+			t.Height = entries[i].Height
+			t.Timestamp = b.Timestamp
+			t.Confirmations = h.Height - t.Height + 1
+			h.Transfers = append(h.Transfers, t)
+		}
+	}
+	for _, t := range bc.TransactionPool {
+		for _, pt := range addressTransfers(blockchainAddress, t) {
+			pt.Pending = true
+			h.Pending = append(h.Pending, pt)
+		}
+	}
+	return h
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"go-blockchain/blockchain/domain/entity"
+)
+
+const (
+	BAN_THRESHOLD    = 100
+	BAN_DURATION_SEC = 60 * 60 * 24
+
+	MISBEHAVIOR_INVALID_CHAIN = 100
+	MISBEHAVIOR_BAD_SIGNATURE = 20
+	MISBEHAVIOR_MALFORMED     = 10
+	MISBEHAVIOR_TIMEOUT       = 5
+)
+
+type banRecord struct {
+	Address string `json:"address"`
+	Until   int64  `json:"until"`
+	Reason  string `json:"reason"`
+}
+
+// Misbehaving raises the score of peer and bans it once the score reaches
+// BAN_THRESHOLD. peer is a verified node ID, the host an unverified request
+// came from, or a neighbor address we dialed ourselves. A banned peer is
+// removed from the neighbors right away.
+func (bcr *blockchainRepository) Misbehaving(bc *entity.Blockchain, peer string, score int, reason string) {
+	bc.MuxBans.Lock()
+	if bc.PeerScores == nil {
+		bc.PeerScores = make(map[string]int)
+	}
+	bc.PeerScores[peer] += score
+	log.Printf("ERROR: peer %s misbehaving (%s), score %d", peer, reason, bc.PeerScores[peer])
+	if bc.PeerScores[peer] < BAN_THRESHOLD {
+		bc.MuxBans.Unlock()
+		return
+	}
+	delete(bc.PeerScores, peer)
+	if bc.Bans == nil {
+		bc.Bans = make(map[string]*entity.Ban)
+	}
+	bc.Bans[peer] = &entity.Ban{
+		Address: peer,
+		Until:   time.Now().Unix() + BAN_DURATION_SEC,
+		Reason:  reason,
+	}
+	bcr.saveBans(bc)
+	bc.MuxBans.Unlock()
+	log.Printf("ERROR: banned peer %s", peer)
+
+	bc.MuxNeighbors.Lock()
+	defer bc.MuxNeighbors.Unlock()
+	neighbors := make([]string, 0, len(bc.Neighbors))
+	for _, n := range bc.Neighbors {
+		if p, ok := bc.Peers[n]; n == peer || (ok && p.NodeID == peer) {
+			delete(bc.Peers, n)
+			continue
+		}
+		neighbors = append(neighbors, n)
+	}
+	bc.Neighbors = neighbors
+}
+
+// IsBanned reports whether peer, or the host it is on, is banned.
+func (bcr *blockchainRepository) IsBanned(bc *entity.Blockchain, peer string) bool {
+	if peer == "" {
+		return false
+	}
+	bc.MuxBans.Lock()
+	defer bc.MuxBans.Unlock()
+
+	keys := []string{peer}
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		keys = append(keys, host)
+	}
+	now := time.Now().Unix()
+	for _, k := range keys {
+		ban, ok := bc.Bans[k]
+		if !ok {
+			continue
+		}
+		if ban.Until > now {
+			return true
+		}
+		delete(bc.Bans, k)
+		bcr.saveBans(bc)
+	}
+	return false
+}
+
+func (bcr *blockchainRepository) Bans(bc *entity.Blockchain) []*entity.Ban {
+	bc.MuxBans.Lock()
+	defer bc.MuxBans.Unlock()
+	now := time.Now().Unix()
+	bans := make([]*entity.Ban, 0, len(bc.Bans))
+	for _, ban := range bc.Bans {
+		if ban.Until > now {
+			bans = append(bans, ban)
+		}
+	}
+	return bans
+}
+
+// ClearBans lifts the ban on address, or every ban when address is empty.
+func (bcr *blockchainRepository) ClearBans(bc *entity.Blockchain, address string) {
+	bc.MuxBans.Lock()
+	defer bc.MuxBans.Unlock()
+	if address == "" {
+		bc.Bans = make(map[string]*entity.Ban)
+		bc.PeerScores = make(map[string]int)
+	} else {
+		delete(bc.Bans, address)
+		delete(bc.PeerScores, address)
+	}
+	bcr.saveBans(bc)
+}
+
+func (bcr *blockchainRepository) LoadBans(bc *entity.Blockchain) {
+	bc.MuxBans.Lock()
+	defer bc.MuxBans.Unlock()
+	bc.Bans = make(map[string]*entity.Ban)
+	if bc.BanFile == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(bc.BanFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("ERROR: %v", err)
+		}
+		return
+	}
+	var records []*banRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		log.Printf("ERROR: %v", err)
+		return
+	}
+	for _, r := range records {
+		bc.Bans[r.Address] = &entity.Ban{Address: r.Address, Until: r.Until, Reason: r.Reason}
+	}
+}
+
+// saveBans must be called with bc.MuxBans held.
+func (bcr *blockchainRepository) saveBans(bc *entity.Blockchain) {
+	if bc.BanFile == "" {
+		return
+	}
+	records := make([]*banRecord, 0, len(bc.Bans))
+	for _, ban := range bc.Bans {
+		records = append(records, &banRecord{Address: ban.Address, Until: ban.Until, Reason: ban.Reason})
+	}
+	m, _ := json.MarshalIndent(records, "", "  ")
+	if err := ioutil.WriteFile(bc.BanFile, m, 0644); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+}
@@ -1,9 +1,6 @@
 package repository
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"time"
 
@@ -27,6 +24,12 @@ func NewBlock(nonce int, previousHash [32]byte, transactions []*entity.Transacti
 	return b
 }
 
+// NewGenesisBlock is the same on every node so that peers can compare
+// genesis hashes during the handshake.
+func NewGenesisBlock(previousHash [32]byte, bits uint32) *entity.Block {
+	return &entity.Block{Bits: bits, PreviousHash: previousHash, Transactions: []*entity.Transaction{}}
+}
+
 func (br *blockRepository) PreviousHash(b *entity.Block) [32]byte {
 	return b.PreviousHash
 }
@@ -41,6 +44,7 @@ func (br *blockRepository) Transactions(b *entity.Block) []*entity.Transaction {
 
 func (br *blockRepository) Print(b *entity.Block, tr repository.TransactionRepository) {
 	fmt.Printf("timestamp       %d\n", b.Timestamp)
+	fmt.Printf("bits            %08x\n", b.Bits)
 	fmt.Printf("nonce           %d\n", b.Nonce)
 	fmt.Printf("previous_hash   %x\n", b.PreviousHash)
 	for _, t := range b.Transactions {
@@ -48,42 +52,7 @@ func (br *blockRepository) Print(b *entity.Block, tr repository.TransactionRepos
 	}
 }
 
+// Hash is the hash of the canonical header, see utils.EncodeHeader.
 func (br *blockRepository) Hash(b *entity.Block) [32]byte {
-	m, _ := json.Marshal(b)
-	return sha256.Sum256([]byte(m))
-}
-
-func (br *blockRepository) MarshalJSON(b *entity.Block) ([]byte, error) {
-	return json.Marshal(struct {
-		Timestamp    int64                 `json:"timestamp"`
-		Nonce        int                   `json:"nonce"`
-		PreviousHash string                `json:"previous_hash"`
-		Transactions []*entity.Transaction `json:"transactions"`
-	}{
-		Timestamp:    b.Timestamp,
-		Nonce:        b.Nonce,
-		PreviousHash: fmt.Sprintf("%x", b.PreviousHash),
-		Transactions: b.Transactions,
-	})
-}
-
-func (br *blockRepository) UnmarshalJSON(b *entity.Block, data []byte) error {
-	var previousHash string
-	v := &struct {
-		Timestamp    *int64                 `json:"timestamp"`
-		Nonce        *int                   `json:"nonce"`
-		PreviousHash *string                `json:"previous_hash"`
-		Transactions *[]*entity.Transaction `json:"transactions"`
-	}{
-		Timestamp:    &b.Timestamp,
-		Nonce:        &b.Nonce,
-		PreviousHash: &previousHash,
-		Transactions: &b.Transactions,
-	}
-	if err := json.Unmarshal(data, &v); err != nil {
-		return err
-	}
-	ph, _ := hex.DecodeString(*v.PreviousHash)
-	copy(b.PreviousHash[:], ph[:32])
-	return nil
+	return b.Hash()
 }
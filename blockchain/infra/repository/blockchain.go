@@ -1,47 +1,67 @@
 package repository
 
 import (
-	"bytes"
+	"context"
 	"crypto/ecdsa"
-	"crypto/sha256"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
+	"net"
 	"strings"
 	"time"
 
 	"go-blockchain/blockchain/domain/entity"
 	"go-blockchain/blockchain/domain/repository"
-	"go-blockchain/blockchain/infra/http/request"
 	"go-blockchain/utils"
+	wir "go-blockchain/wallet/infra/repository"
 )
 
 const (
-	MINING_DIFFICULTY = 3
-	MINING_SENDER     = "THE BLOCKCHAIN"
-	MINING_REWARD     = 1.0
-	MINING_TIMER_SEC  = 20
+	MINING_BITS      = 0x1f0fffff
+	MINING_SENDER    = "THE BLOCKCHAIN"
+	MINING_REWARD    = 1.0
+	MINING_TIMER_SEC = 20
 
 	BLOCKCHAIN_PORT_RANGE_START      = 5000
 	BLOCKCHAIN_PORT_RANGE_END        = 5003
 	NEIGHBOR_IP_RANGE_START          = 0
 	NEIGHBOR_IP_RANGE_END            = 1
 	BLOCKCHIN_NEIGHBOR_SYNC_TIME_SEC = 20
+
+	SEEN_TRANSACTION_TTL_SEC = 600
+
+	PROTOCOL_VERSION     = 6
+	MIN_PROTOCOL_VERSION = 6
+	DEFAULT_NETWORK_ID   = "go-blockchain"
 )
 
-type blockchainRepository struct{}
+type blockchainRepository struct {
+	pt    repository.PeerTransport
+	ce    repository.ConsensusEngine
+	clock repository.Clock
+	tr    repository.TransactionRepository
+}
 
-func NewBlockchainRepository() repository.BlockchainRepository {
-	return &blockchainRepository{}
+func NewBlockchainRepository(pt repository.PeerTransport, ce repository.ConsensusEngine, clock repository.Clock) repository.BlockchainRepository {
+	return &blockchainRepository{pt: pt, ce: ce, clock: clock, tr: NewTransactionRepository()}
 }
 
-func NewBlockchain(br repository.BlockRepository, bcr repository.BlockchainRepository, blockchainAddress string, port uint16) *entity.Blockchain {
-	b := &entity.Block{}
+// NewBlockchain starts a chain on the consensus engine's genesis block.
+// bits is the proof-of-work target, unused by other engines.
+func NewBlockchain(br repository.BlockRepository, bcr repository.BlockchainRepository, blockchainAddress string, port uint16, bits uint32) *entity.Blockchain {
 	bc := new(entity.Blockchain)
 	bc.BlockchainAddress = blockchainAddress
-	bcr.CreateBlock(bc, 0, br.Hash(b))
+	bc.Bits = bits
+	genesis := bcr.Consensus().Genesis(bc)
+	bc.Chain = append(bc.Chain, genesis)
+	bc.GenesisHash = br.Hash(genesis)
 	bc.Port = port
+	bc.PeerPort = port
+	bc.Address = fmt.Sprintf("%s:%d", utils.GetHost(), port)
+	bc.NetworkID = DEFAULT_NETWORK_ID
+	bc.Miner = NewMiner(MINING_TIMER_SEC * time.Second)
 	return bc
 }
 
@@ -49,24 +69,134 @@ func (bcr *blockchainRepository) Chain(bc *entity.Blockchain) []*entity.Block {
 	return bc.Chain
 }
 
+func (bcr *blockchainRepository) Consensus() repository.ConsensusEngine {
+	return bcr.ce
+}
+
 func (bcr *blockchainRepository) Run(bc *entity.Blockchain, br repository.BlockRepository) {
 	bcr.StartSyncNeighbors(bc)
 	bcr.ResolveConflicts(bc, br)
-	bcr.StartMining(bc, br)
 }
 
+// SetNeighbors scans for nodes and keeps only the ones that complete a
+// compatible handshake. The scan runs without holding MuxNeighbors, since
+// the peers we handshake with may be handshaking with us at the same time.
 func (bcr *blockchainRepository) SetNeighbors(bc *entity.Blockchain) {
-	bc.Neighbors = utils.FindNeighbors(
-		utils.GetHost(), bc.Port,
+	offset := bc.PeerPort - bc.Port
+	candidates := utils.FindNeighbors(
+		utils.GetHost(), bc.PeerPort,
 		NEIGHBOR_IP_RANGE_START, NEIGHBOR_IP_RANGE_END,
-		BLOCKCHAIN_PORT_RANGE_START, BLOCKCHAIN_PORT_RANGE_END)
-	log.Printf("%v", bc.Neighbors)
+		BLOCKCHAIN_PORT_RANGE_START+offset, BLOCKCHAIN_PORT_RANGE_END+offset)
+
+	local := bcr.LocalPeer(bc)
+	neighbors := make([]string, 0)
+	peers := make(map[string]*entity.Peer)
+	advertised := make(map[string]bool)
+	for _, n := range candidates {
+		if bcr.IsBanned(bc, n) {
+			continue
+		}
+		remote, ok := bcr.pt.Handshake(bc, n, local)
+		if !ok {
+			log.Printf("ERROR: handshake with %s failed", n)
+			continue
+		}
+		if bcr.IsBanned(bc, remote.NodeID) {
+			continue
+		}
+		if !bcr.compatiblePeer(bc, remote) {
+			log.Printf("ERROR: dropped incompatible peer %s", n)
+			continue
+		}
+		// The same node can answer on several scanned addresses.
+		if advertised[remote.Address] {
+			continue
+		}
+		advertised[remote.Address] = true
+		remote.LastSeen = time.Now().Unix()
+		neighbors = append(neighbors, n)
+		peers[n] = remote
+	}
+
+	bc.MuxNeighbors.Lock()
+	bc.Neighbors = neighbors
+	bc.Peers = peers
+	bc.MuxNeighbors.Unlock()
+	log.Printf("%v", neighbors)
 }
 
 func (bcr *blockchainRepository) SyncNeighbors(bc *entity.Blockchain) {
+	bcr.SetNeighbors(bc)
+}
+
+func (bcr *blockchainRepository) LocalPeer(bc *entity.Blockchain) *entity.Peer {
+	nodeID := ""
+	if bc.Identity != nil {
+		nodeID = NodeID(&bc.Identity.PublicKey)
+	}
+	return &entity.Peer{
+		NodeID:          nodeID,
+		ProtocolVersion: PROTOCOL_VERSION,
+		NetworkID:       bc.NetworkID,
+		GenesisHash:     bc.GenesisHash,
+		Height:          len(bc.Chain) - 1,
+		CumulativeWork:  bcr.CumulativeWork(bc.Chain),
+		Address:         bc.Address,
+	}
+}
+
+// AcceptHandshake answers a handshake started by remote, whose NodeID the
+// transport has verified. A compatible peer becomes a neighbor right away;
+// the local info is returned either way so the other side can tell why it
+// was refused. The address a peer announces is its own claim, so it cannot
+// take over the neighbor entry of another node.
+func (bcr *blockchainRepository) AcceptHandshake(bc *entity.Blockchain, remote *entity.Peer) (*entity.Peer, bool) {
+	local := bcr.LocalPeer(bc)
+	if bcr.IsBanned(bc, remote.NodeID) || !bcr.compatiblePeer(bc, remote) {
+		log.Printf("ERROR: refused handshake from %s", remote.Address)
+		return local, false
+	}
+
 	bc.MuxNeighbors.Lock()
 	defer bc.MuxNeighbors.Unlock()
-	bcr.SetNeighbors(bc)
+	remote.LastSeen = time.Now().Unix()
+	if bc.Peers == nil {
+		bc.Peers = make(map[string]*entity.Peer)
+	}
+	if p, ok := bc.Peers[remote.Address]; ok && p.NodeID != remote.NodeID {
+		log.Printf("ERROR: node %s announced the address of node %s", remote.NodeID, p.NodeID)
+		return local, false
+	} else if !ok {
+		bc.Neighbors = append(bc.Neighbors, remote.Address)
+	}
+	bc.Peers[remote.Address] = remote
+	return local, true
+}
+
+func (bcr *blockchainRepository) compatiblePeer(bc *entity.Blockchain, p *entity.Peer) bool {
+	return bcr.IsAllowedPeer(bc, p.NodeID) &&
+		p.ProtocolVersion >= MIN_PROTOCOL_VERSION &&
+		p.NetworkID == bc.NetworkID &&
+		p.GenesisHash == bc.GenesisHash &&
+		p.Address != bc.Address
+}
+
+func (bcr *blockchainRepository) Peers(bc *entity.Blockchain) []*entity.Peer {
+	bc.MuxNeighbors.Lock()
+	defer bc.MuxNeighbors.Unlock()
+	peers := make([]*entity.Peer, 0, len(bc.Peers))
+	for _, n := range bc.Neighbors {
+		if p, ok := bc.Peers[n]; ok {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// CumulativeWork is the weight of chain under the consensus engine. The
+// handshake advertises it and ResolveConflicts adopts the heaviest chain.
+func (bcr *blockchainRepository) CumulativeWork(chain []*entity.Block) uint64 {
+	return bcr.ce.Weight(chain)
 }
 
 func (bcr *blockchainRepository) StartSyncNeighbors(bc *entity.Blockchain) {
@@ -104,14 +234,11 @@ func (bcr *blockchainRepository) UnmarshalJSON(bc *entity.Blockchain, data []byt
 
 func (bcr *blockchainRepository) CreateBlock(bc *entity.Blockchain, nonce int, previousHash [32]byte) *entity.Block {
 	b := NewBlock(nonce, previousHash, bc.TransactionPool)
+	b.Height = len(bc.Chain)
 	bc.Chain = append(bc.Chain, b)
 	bc.TransactionPool = []*entity.Transaction{}
 	for _, n := range bc.Neighbors {
-		endpoint := fmt.Sprintf("http://%s/transactions", n)
-		client := &http.Client{}
-		req, _ := http.NewRequest("DELETE", endpoint, nil)
-		resp, _ := client.Do(req)
-		log.Printf("%v", resp)
+		bcr.pt.AnnounceBlock(bc, n, b)
 	}
 	return b
 }
@@ -131,39 +258,65 @@ func (bcr *blockchainRepository) Print(br repository.BlockRepository, tr reposit
 
 func (bcr *blockchainRepository) CreateTransaction(bc *entity.Blockchain, sender string, recipient string, value float32,
 	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	isTransacted := bcr.AddTransaction(bc, sender, recipient, value, senderPublicKey, s)
+	t := NewSignedTransaction(sender, recipient, value, senderPublicKey, s)
+	if bcr.transactionSeen(bc, bcr.tr.Hash(t)) {
+		log.Println("ERROR: transaction already seen")
+		return false
+	}
+	isTransacted := bcr.addTransaction(bc, t)
 
-	if isTransacted {
-		for _, n := range bc.Neighbors {
-			publicKeyStr := fmt.Sprintf("%064x%064x", senderPublicKey.X.Bytes(),
-				senderPublicKey.Y.Bytes())
-			signatureStr := s.String()
-			bt := &request.TransactionRequest{
-				SenderBlockchainAddress: &sender, RecipientBlockchainAddress: &recipient, SenderPublicKey: &publicKeyStr, Value: &value, Signature: &signatureStr}
-			m, _ := json.Marshal(bt)
-			buf := bytes.NewBuffer(m)
-			endpoint := fmt.Sprintf("http://%s/transactions", n)
-			client := &http.Client{}
-			req, _ := http.NewRequest("PUT", endpoint, buf)
-			resp, _ := client.Do(req)
-			log.Printf("%v", resp)
-		}
+	if isTransacted && bcr.markTransactionSeen(bc, bcr.tr.Hash(t)) {
+		bcr.RelayTransaction(bc, t)
 	}
 
 	return isTransacted
 }
 
+// Pay sends value from the node's own reward address, signed with
+// bc.RewardKey, as a regular transaction.
+func (bcr *blockchainRepository) Pay(bc *entity.Blockchain, recipient string, value float32) bool {
+	if bc.RewardKey == nil {
+		log.Println("ERROR: no reward key to pay from")
+		return false
+	}
+	t := NewTransaction(bc.BlockchainAddress, recipient, value)
+	h := utils.TransactionSigningHash(t.SenderBlockchainAddress, t.RecipientBlockchainAddress, t.Value)
+	r, s, err := ecdsa.Sign(rand.Reader, bc.RewardKey, h[:])
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return false
+	}
+	return bcr.CreateTransaction(bc, t.SenderBlockchainAddress, t.RecipientBlockchainAddress, t.Value,
+		&bc.RewardKey.PublicKey, &utils.Signature{R: r, S: s})
+}
+
 func (bcr *blockchainRepository) AddTransaction(bc *entity.Blockchain, sender string, recipient string, value float32,
 	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	t := NewTransaction(sender, recipient, value)
+	return bcr.addTransaction(bc, NewSignedTransaction(sender, recipient, value, senderPublicKey, s))
+}
 
-	if sender == MINING_SENDER {
-		bc.TransactionPool = append(bc.TransactionPool, t)
-		return true
+func (bcr *blockchainRepository) addTransaction(bc *entity.Blockchain, t *entity.Transaction) bool {
+	// Rewards only enter the chain as the coinbase of a block.
+	if t.SenderBlockchainAddress == MINING_SENDER {
+		log.Println("ERROR: mining rewards are not transactions")
+		return false
+	}
+
+	if !(t.Value > 0) {
+		log.Println("ERROR: transaction value must be positive")
+		return false
+	}
+	if _, err := t.CheckedID(); err != nil {
+		log.Printf("ERROR: %v", err)
+		return false
+	}
+	if bcr.knownTransaction(bc, bcr.tr.Hash(t)) {
+		log.Println("ERROR: transaction already pooled or confirmed")
+		return false
 	}
 
-	if bcr.VerifyTransactionSignature(bc, senderPublicKey, s, t) {
-		if bcr.CalculateTotalAmount(bc, sender) < value {
+	if bcr.VerifyTransactionSignature(bc, t.SenderPublicKey, t.Signature, t) {
+		if bcr.SpendableAmount(bc, t.SenderBlockchainAddress) < t.Value {
 			log.Println("ERROR: Not enough balance in a wallet")
 			return false
 		}
@@ -176,10 +329,132 @@ func (bcr *blockchainRepository) AddTransaction(bc *entity.Blockchain, sender st
 
 }
 
+// knownTransaction reports whether the transaction with id is in the pool
+// or the chain already, so a copy of a payment cannot be spent again.
+func (bcr *blockchainRepository) knownTransaction(bc *entity.Blockchain, id [32]byte) bool {
+	for _, t := range bc.TransactionPool {
+		if bcr.tr.Hash(t) == id {
+			return true
+		}
+	}
+	for _, b := range bc.Chain {
+		for _, t := range b.Transactions {
+			if bcr.tr.Hash(t) == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReceiveTransaction handles a transaction pushed by a neighbor. Transactions
+// already seen are ignored, new valid ones are relayed onward. Only accepted
+// transactions are marked seen, so an invalid copy arriving first does not
+// keep the valid one out.
+func (bcr *blockchainRepository) ReceiveTransaction(bc *entity.Blockchain, peer string, sender string, recipient string, value float32,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
+	t := NewSignedTransaction(sender, recipient, value, senderPublicKey, s)
+	if bcr.transactionSeen(bc, bcr.tr.Hash(t)) {
+		return true
+	}
+
+	if sender == MINING_SENDER {
+		bcr.Misbehaving(bc, peer, MISBEHAVIOR_BAD_SIGNATURE, "relayed a mining reward")
+		return false
+	}
+	if !bcr.VerifyTransactionSignature(bc, senderPublicKey, s, t) {
+		bcr.Misbehaving(bc, peer, MISBEHAVIOR_BAD_SIGNATURE, "bad transaction signature")
+		return false
+	}
+
+	isAdded := bcr.addTransaction(bc, t)
+	if isAdded && bcr.markTransactionSeen(bc, bcr.tr.Hash(t)) {
+		bcr.RelayTransaction(bc, t)
+	}
+	return isAdded
+}
+
+func (bcr *blockchainRepository) MissingTransactions(bc *entity.Blockchain, hashes [][32]byte) [][32]byte {
+	bc.MuxSeen.Lock()
+	defer bc.MuxSeen.Unlock()
+
+	missing := make([][32]byte, 0)
+	for _, h := range hashes {
+		if _, ok := bc.SeenTransactions[h]; !ok {
+			missing = append(missing, h)
+		}
+	}
+	return missing
+}
+
+// RelayTransaction announces t to every neighbor and sends it to the ones
+// that ask for it.
+func (bcr *blockchainRepository) RelayTransaction(bc *entity.Blockchain, t *entity.Transaction) {
+	h := bcr.tr.Hash(t)
+	for _, n := range bc.Neighbors {
+		wanted, err := bcr.pt.AnnounceTransactions(bc, n, [][32]byte{h})
+		if err != nil {
+			// Refusing is not misbehaving, only failing to answer is.
+			if isTimeout(err) {
+				bcr.Misbehaving(bc, n, MISBEHAVIOR_TIMEOUT, "no answer to inventory")
+			}
+			continue
+		}
+		if len(wanted) == 0 {
+			continue
+		}
+		bcr.pt.SendTransaction(bc, n, t, t.SenderPublicKey, t.Signature)
+	}
+}
+
+// transactionSeen reports whether h is in the seen-set.
+func (bcr *blockchainRepository) transactionSeen(bc *entity.Blockchain, h [32]byte) bool {
+	bc.MuxSeen.Lock()
+	defer bc.MuxSeen.Unlock()
+	seenAt, ok := bc.SeenTransactions[h]
+	return ok && time.Now().Unix()-seenAt <= SEEN_TRANSACTION_TTL_SEC
+}
+
+// isTimeout reports whether err is a neighbor failing to answer in time.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// markTransactionSeen records h in the seen-set and reports whether it was
+// new. Entries older than SEEN_TRANSACTION_TTL_SEC are dropped on the way.
+func (bcr *blockchainRepository) markTransactionSeen(bc *entity.Blockchain, h [32]byte) bool {
+	bc.MuxSeen.Lock()
+	defer bc.MuxSeen.Unlock()
+
+	now := time.Now().Unix()
+	if bc.SeenTransactions == nil {
+		bc.SeenTransactions = make(map[[32]byte]int64)
+	}
+	for k, seenAt := range bc.SeenTransactions {
+		if now-seenAt > SEEN_TRANSACTION_TTL_SEC {
+			delete(bc.SeenTransactions, k)
+		}
+	}
+
+	if _, ok := bc.SeenTransactions[h]; ok {
+		return false
+	}
+	bc.SeenTransactions[h] = now
+	return true
+}
+
+// VerifyTransactionSignature checks that s signs t with senderPublicKey and
+// that the sender's address is the one made from that key.
 func (bcr *blockchainRepository) VerifyTransactionSignature(bc *entity.Blockchain,
 	senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *entity.Transaction) bool {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
+	if senderPublicKey == nil || s == nil || s.R == nil || s.S == nil {
+		return false
+	}
+	if wir.BlockchainAddressFromPublicKey(senderPublicKey) != t.SenderBlockchainAddress {
+		return false
+	}
+	h := utils.TransactionSigningHash(t.SenderBlockchainAddress, t.RecipientBlockchainAddress, t.Value)
 	return ecdsa.Verify(senderPublicKey, h[:], s.R, s.S)
 }
 
@@ -187,62 +462,164 @@ func (bcr *blockchainRepository) CopyTransactionPool(bc *entity.Blockchain) []*e
 	transactions := make([]*entity.Transaction, 0)
 	for _, t := range bc.TransactionPool {
 		transactions = append(transactions,
-			NewTransaction(t.SenderBlockchainAddress,
+			NewSignedTransaction(t.SenderBlockchainAddress,
 				t.RecipientBlockchainAddress,
-				t.Value))
+				t.Value, t.SenderPublicKey, t.Signature))
 	}
 	return transactions
 }
 
-func (bcr *blockchainRepository) ValidProof(bc *entity.Blockchain, br repository.BlockRepository, nonce int, previousHash [32]byte, transactions []*entity.Transaction, difficulty int) bool {
-	zeros := strings.Repeat("0", difficulty)
-	guessBlock := entity.Block{Timestamp: 0, Nonce: nonce, PreviousHash: previousHash, Transactions: transactions}
-	guessHashStr := fmt.Sprintf("%x", br.Hash(&guessBlock))
-	return guessHashStr[:difficulty] == zeros
-}
+// Mining seals a template of the current tip and the pool with the
+// consensus engine. bc.Mux is only held while the template is taken and
+// while the block is appended, so a block arriving meanwhile cancels the
+// seal and mining starts over on the new tip.
+func (bcr *blockchainRepository) Mining(bc *entity.Blockchain, br repository.BlockRepository) bool {
+	bc.Miner.MuxRun.Lock()
+	defer bc.Miner.MuxRun.Unlock()
+	for {
+		bc.Mux.Lock()
+		if bc.ShuttingDown {
+			bc.Mux.Unlock()
+			return false
+		}
+
+		bc.Miner.Mux.Lock()
+		onlyWithTransactions := bc.Miner.OnlyWithTransactions
+		bc.Miner.Mux.Unlock()
+		if onlyWithTransactions && len(bc.TransactionPool) == 0 {
+			bc.Mux.Unlock()
+			log.Println("action=mining, status=skipped, transaction pool is empty")
+			return false
+		}
 
-func (bcr *blockchainRepository) ProofOfWork(bc *entity.Blockchain, br repository.BlockRepository) int {
-	transactions := bcr.CopyTransactionPool(bc)
-	previousHash := br.Hash(bcr.LastBlock(bc))
-	nonce := 0
-	for !bcr.ValidProof(bc, br, nonce, previousHash, transactions, MINING_DIFFICULTY) {
-		nonce += 1
+		tmpl := bcr.newMiningTemplate(bc, br, bc.BlockchainAddress)
+		if tmpl == nil {
+			bc.Mux.Unlock()
+			log.Printf("action=mining, status=skipped, consensus=%s, height=%d", bcr.ce.Name(), len(bc.Chain))
+			return false
+		}
+		template := tmpl.Block
+		ctx, cancel := context.WithCancel(context.Background())
+		bc.CancelMining = cancel
+		bc.Mux.Unlock()
+
+		found := bcr.ce.Seal(ctx, bc, template)
+
+		bc.Mux.Lock()
+		bc.CancelMining = nil
+		cancel()
+		tipChanged := template.PreviousHash != br.Hash(bcr.LastBlock(bc))
+		if !found && !tipChanged {
+			// Cancelled by StopMining or Shutdown rather than by a new tip.
+			bc.Mux.Unlock()
+			log.Println("action=mining, status=cancelled")
+			return false
+		}
+		if tipChanged {
+			bc.Mux.Unlock()
+			log.Println("action=mining, status=stale, template discarded")
+			continue
+		}
+		bcr.appendBlock(bc, template)
+		bc.Miner.Mux.Lock()
+		bc.Miner.LastBlock = template
+		bc.Miner.LastBlockHeight = len(bc.Chain) - 1
+		bc.Miner.Mux.Unlock()
+		bc.Mux.Unlock()
+		bc.MuxHashrate.Lock()
+		log.Printf("action=mining, status=success, hashrate=%.0f", bc.Hashrate)
+		bc.MuxHashrate.Unlock()
+
+		bcr.announceBlock(bc, template)
+		return true
 	}
-	return nonce
 }
 
-func (bcr *blockchainRepository) Mining(bc *entity.Blockchain, br repository.BlockRepository) bool {
+// appendBlock adds b to the chain and drops its transactions from the pool.
+// It must be called with bc.Mux held.
+func (bcr *blockchainRepository) appendBlock(bc *entity.Blockchain, b *entity.Block) {
+	b.Height = len(bc.Chain)
+	bc.Chain = append(bc.Chain, b)
+	bcr.removeTransactions(bc, b)
+}
+
+// RemoveBlockTransactions drops the transactions of a block a neighbor
+// announced from the pool. The others stay for the next block. Only a
+// sealed block on the local tip counts, so a made-up block cannot empty
+// the pool; it reports whether b was one.
+func (bcr *blockchainRepository) RemoveBlockTransactions(bc *entity.Blockchain, b *entity.Block) bool {
 	bc.Mux.Lock()
 	defer bc.Mux.Unlock()
+	parent := bcr.LastBlock(bc)
+	sealed := *b
+	sealed.Height = len(bc.Chain)
+	if b.PreviousHash != parent.Hash() || !bcr.ce.VerifySeal(bc, parent, &sealed) {
+		return false
+	}
+	bcr.removeTransactions(bc, b)
+	return true
+}
 
-	/*
-		if len(bc.transactionPool) == 0 {
-			return false
+// adoptChain replaces bc.Chain with chain. Transactions chain confirms
+// leave the pool and those of the blocks it drops go back into it. It must
+// be called with bc.Mux held.
+func (bcr *blockchainRepository) adoptChain(bc *entity.Blockchain, chain []*entity.Block) {
+	confirmed := make(map[[32]byte]bool)
+	for _, b := range chain {
+		for _, t := range b.Transactions {
+			confirmed[bcr.tr.Hash(t)] = true
 		}
-	*/
-
-	bcr.AddTransaction(bc, MINING_SENDER, bc.BlockchainAddress, MINING_REWARD, nil, nil)
-	nonce := bcr.ProofOfWork(bc, br)
-	previousHash := br.Hash(bcr.LastBlock(bc))
-	bcr.CreateBlock(bc, nonce, previousHash)
-	log.Println("action=mining, status=success")
+	}
+	pool := make([]*entity.Transaction, 0, len(bc.TransactionPool))
+	for _, b := range bc.Chain[len(bc.Chain)-reorgDepth(bc.Chain, chain):] {
+		for _, t := range b.Transactions[1:] {
+			if id := bcr.tr.Hash(t); !confirmed[id] {
+				confirmed[id] = true
+				pool = append(pool, t)
+			}
+		}
+	}
+	for _, t := range bc.TransactionPool {
+		if id := bcr.tr.Hash(t); !confirmed[id] {
+			confirmed[id] = true
+			pool = append(pool, t)
+		}
+	}
+	bc.Chain = chain
+	bc.TransactionPool = pool
+}
 
-	for _, n := range bc.Neighbors {
-		endpoint := fmt.Sprintf("http://%s/consensus", n)
-		client := &http.Client{}
-		req, _ := http.NewRequest("PUT", endpoint, nil)
-		resp, _ := client.Do(req)
-		log.Printf("%v", resp)
+// removeTransactions must be called with bc.Mux held.
+func (bcr *blockchainRepository) removeTransactions(bc *entity.Blockchain, b *entity.Block) {
+	included := make(map[[32]byte]bool)
+	for _, t := range b.Transactions {
+		included[bcr.tr.Hash(t)] = true
+	}
+	pool := make([]*entity.Transaction, 0, len(bc.TransactionPool))
+	for _, t := range bc.TransactionPool {
+		if !included[bcr.tr.Hash(t)] {
+			pool = append(pool, t)
+		}
 	}
+	bc.TransactionPool = pool
+}
 
-	return true
+// cancelMining stops a running proof of work because the tip changed. It
+// must be called with bc.Mux held.
+func (bcr *blockchainRepository) cancelMining(bc *entity.Blockchain) {
+	if bc.CancelMining != nil {
+		bc.CancelMining()
+		bc.CancelMining = nil
+	}
 }
 
-func (bcr *blockchainRepository) StartMining(bc *entity.Blockchain, br repository.BlockRepository) {
-	bcr.Mining(bc, br)
-	_ = time.AfterFunc(time.Second*MINING_TIMER_SEC, func() {
-		bcr.StartMining(bc, br)
-	})
+// Shutdown stops mining for good.
+func (bcr *blockchainRepository) Shutdown(bc *entity.Blockchain) {
+	bcr.StopMining(bc)
+	bc.Mux.Lock()
+	defer bc.Mux.Unlock()
+	bc.ShuttingDown = true
+	bcr.cancelMining(bc)
 }
 
 func (bcr *blockchainRepository) CalculateTotalAmount(bc *entity.Blockchain, blockchainAddress string) float32 {
@@ -263,7 +640,13 @@ func (bcr *blockchainRepository) CalculateTotalAmount(bc *entity.Blockchain, blo
 }
 
 func (bcr *blockchainRepository) ValidChain(bc *entity.Blockchain, br repository.BlockRepository, chain []*entity.Block) bool {
+	if len(chain) == 0 || br.Hash(chain[0]) != bc.GenesisHash || !validCheckpoints(bc, chain) {
+		return false
+	}
 	preBlock := chain[0]
+	preBlock.Height = 0
+	l := newLedger(bc.CoinbaseMaturity)
+	confirmed := make(map[[32]byte]bool)
 	currentIndex := 1
 	for currentIndex < len(chain) {
 		b := chain[currentIndex]
@@ -271,7 +654,30 @@ func (bcr *blockchainRepository) ValidChain(bc *entity.Blockchain, br repository
 			return false
 		}
 
-		if !bcr.ValidProof(bc, br, br.Nonce(b), br.PreviousHash(b), br.Transactions(b), MINING_DIFFICULTY) {
+		b.Height = currentIndex
+		if !bcr.validTimestamp(bc, chain, currentIndex) || !bcr.ce.VerifySeal(bc, preBlock, b) {
+			return false
+		}
+		if !validCoinbase(bc, b) {
+			return false
+		}
+		for _, t := range b.Transactions[1:] {
+			if !(t.Value > 0) {
+				log.Printf("ERROR: block %d sends a non-positive value", currentIndex)
+				return false
+			}
+			if !bcr.VerifyTransactionSignature(bc, t.SenderPublicKey, t.Signature, t) {
+				log.Printf("ERROR: block %d has a transaction not signed by its sender", currentIndex)
+				return false
+			}
+			id := bcr.tr.Hash(t)
+			if confirmed[id] {
+				log.Printf("ERROR: block %d repeats transaction %x", currentIndex, id)
+				return false
+			}
+			confirmed[id] = true
+		}
+		if !l.apply(b, currentIndex) {
 			return false
 		}
 
@@ -281,31 +687,49 @@ func (bcr *blockchainRepository) ValidChain(bc *entity.Blockchain, br repository
 	return true
 }
 
+// ResolveConflicts adopts the heaviest valid chain among the neighbors'
+// when it outweighs ours and leaves the blocks deeper than bc.FinalityDepth
+// in place.
 func (bcr *blockchainRepository) ResolveConflicts(bc *entity.Blockchain, br repository.BlockRepository) bool {
-	var longestChain []*entity.Block = nil
-	maxLength := len(bc.Chain)
+	var heaviestChain []*entity.Block = nil
+	maxWeight := bcr.CumulativeWork(bc.Chain)
 
 	for _, n := range bc.Neighbors {
-		endpoint := fmt.Sprintf("http://%s/chain", n)
-		resp, _ := http.Get(endpoint)
-		if resp.StatusCode == 200 {
-			var bcResp entity.Blockchain
-			decoder := json.NewDecoder(resp.Body)
-			_ = decoder.Decode(&bcResp)
-
-			chain := bcr.Chain(&bcResp)
-
-			if len(chain) > maxLength && bcr.ValidChain(bc, br, chain) {
-				maxLength = len(chain)
-				longestChain = chain
-			}
+		chain, ok := bcr.pt.RequestChain(bc, n)
+		if !ok {
+			bcr.Misbehaving(bc, n, MISBEHAVIOR_TIMEOUT, "no answer to chain request")
+			continue
+		}
+
+		weight := bcr.CumulativeWork(chain)
+		if weight <= maxWeight {
+			continue
+		}
+		if !bcr.ValidChain(bc, br, chain) {
+			bcr.Misbehaving(bc, n, MISBEHAVIOR_INVALID_CHAIN, "served an invalid chain")
+			continue
 		}
+		if !bcr.allowReorg(bc, n, reorgDepth(bc.Chain, chain)) {
+			continue
+		}
+		maxWeight = weight
+		heaviestChain = chain
 	}
 
-	if longestChain != nil {
-		bc.Chain = longestChain
-		log.Printf("Resovle confilicts replaced")
-		return true
+	if heaviestChain != nil {
+		// Our own miner may have appended a block while we were asking.
+		bc.Mux.Lock()
+		replaced := maxWeight > bcr.CumulativeWork(bc.Chain) &&
+			(bc.FinalityDepth <= 0 || reorgDepth(bc.Chain, heaviestChain) <= bc.FinalityDepth)
+		if replaced {
+			bcr.adoptChain(bc, heaviestChain)
+			bcr.cancelMining(bc)
+		}
+		bc.Mux.Unlock()
+		if replaced {
+			log.Printf("Resovle confilicts replaced")
+			return true
+		}
 	}
 	log.Printf("Resovle conflicts not replaced")
 	return false
@@ -1,16 +1,25 @@
 package repository
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"go-blockchain/blockchain/domain/entity"
 	"go-blockchain/blockchain/domain/repository"
 	"go-blockchain/blockchain/infra/http/request"
 	"go-blockchain/blockchain/infra/http/response"
+	"go-blockchain/blockchain/infra/p2p"
 	"go-blockchain/utils"
 	wdr "go-blockchain/wallet/domain/repository"
 	wir "go-blockchain/wallet/infra/repository"
@@ -25,7 +34,10 @@ func NewBlockchainServerRepository() repository.BlockchainServerRepository {
 var cache map[string]*entity.Blockchain = make(map[string]*entity.Blockchain)
 
 func NewBlockchainServer(port uint16) *entity.BlockchainServer {
-	return &entity.BlockchainServer{Port: port}
+	return &entity.BlockchainServer{Port: port, PeerPort: port, PeerTransport: "http", NetworkID: DEFAULT_NETWORK_ID,
+		MiningBits: MINING_BITS, MiningInterval: MINING_TIMER_SEC * time.Second, MineOnStart: true,
+		HalvingInterval: HALVING_INTERVAL, CoinbaseMaturity: COINBASE_MATURITY, MaxFutureDrift: MAX_FUTURE_DRIFT_SEC * time.Second,
+		FinalityDepth: FINALITY_DEPTH, ReorgAlertDepth: REORG_ALERT_DEPTH}
 }
 
 func (bsr *blockchainServerRepository) Port(bs *entity.BlockchainServer) uint16 {
@@ -36,7 +48,44 @@ func (bsr *blockchainServerRepository) GetBlockchain(bs *entity.BlockchainServer
 	bc, ok := cache["blockchain"]
 	if !ok {
 		minersWallet := wir.NewWallet()
-		bc = NewBlockchain(br, bcr, wr.BlockchainAddress(minersWallet), bsr.Port(bs))
+		bc = NewBlockchain(br, bcr, wr.BlockchainAddress(minersWallet), bsr.Port(bs), bs.MiningBits)
+		bc.RewardKey = wr.PrivateKey(minersWallet)
+		bc.NetworkID = bs.NetworkID
+		bc.PeerPort = bs.PeerPort
+		bc.Address = fmt.Sprintf("%s:%d", utils.GetHost(), bs.PeerPort)
+		bc.MiningWorkers = bs.MiningWorkers
+		bc.Miner.Interval = bs.MiningInterval
+		bc.Miner.OnlyWithTransactions = bs.MiningOnlyWithTransactions
+		bc.HalvingInterval = bs.HalvingInterval
+		bc.CoinbaseMaturity = bs.CoinbaseMaturity
+		bc.MaxFutureDrift = bs.MaxFutureDrift
+		bc.FinalityDepth = bs.FinalityDepth
+		bc.ReorgAlertDepth = bs.ReorgAlertDepth
+		if bs.CheckpointsFile != "" {
+			checkpoints, err := LoadCheckpoints(bs.CheckpointsFile)
+			if err != nil {
+				log.Fatalf("ERROR: %v", err)
+			}
+			bc.Checkpoints = checkpoints
+		}
+		bc.BanFile = bs.BanFile
+		bcr.LoadBans(bc)
+		if bs.IdentityFile != "" {
+			identity, err := LoadIdentity(bs.IdentityFile)
+			if err != nil {
+				log.Fatalf("ERROR: %v", err)
+			}
+			bc.Identity = identity
+			log.Printf("node_id %v", NodeID(&identity.PublicKey))
+		}
+		bc.RequirePeerCert = bs.TLSCAFile != ""
+		if bs.AllowedPeersFile != "" {
+			allowed, err := LoadAllowedPeers(bs.AllowedPeersFile)
+			if err != nil {
+				log.Fatalf("ERROR: %v", err)
+			}
+			bc.AllowedPeers = allowed
+		}
 		cache["blockchain"] = bc
 		log.Printf("private_key %v", wr.PrivateKeyStr(minersWallet))
 		log.Printf("publick_key %v", wr.PublicKeyStr(minersWallet))
@@ -45,6 +94,62 @@ func (bsr *blockchainServerRepository) GetBlockchain(bs *entity.BlockchainServer
 	return bc
 }
 
+// authenticatePeer checks that a node-to-node request is signed by an
+// allowed, unbanned node. It answers the request itself when it is not and
+// returns the peer's node ID otherwise. Until the signature is verified the
+// request is only attributed to the host it came from, so a forged request
+// can neither get another node banned nor dodge a ban.
+func (bsr *blockchainServerRepository) authenticatePeer(bc *entity.Blockchain, bcr repository.BlockchainRepository, w http.ResponseWriter, req *http.Request) (string, bool) {
+	if bc.RequirePeerCert && (req.TLS == nil || len(req.TLS.VerifiedChains) == 0) {
+		log.Printf("ERROR: peer request without client certificate from %s", req.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, string(utils.JsonStatus("fail")))
+		return "", false
+	}
+	host := RemoteHost(req)
+	if bcr.IsBanned(bc, host) {
+		log.Printf("ERROR: request from banned host %s", host)
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, string(utils.JsonStatus("fail")))
+		return "", false
+	}
+	nodeID, ok := VerifyPeerRequest(bc, req)
+	if !ok {
+		log.Printf("ERROR: bad or replayed peer signature from %s", req.RemoteAddr)
+		bcr.Misbehaving(bc, host, MISBEHAVIOR_BAD_SIGNATURE, "bad message signature")
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, string(utils.JsonStatus("fail")))
+		return "", false
+	}
+	if bcr.IsBanned(bc, nodeID) {
+		log.Printf("ERROR: request from banned node %s", nodeID)
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, string(utils.JsonStatus("fail")))
+		return "", false
+	}
+	if !bcr.IsAllowedPeer(bc, nodeID) {
+		log.Printf("ERROR: node %s is not allowed", nodeID)
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, string(utils.JsonStatus("fail")))
+		return "", false
+	}
+	return nodeID, true
+}
+
+// adminOnly restricts admin endpoints to requests from the local machine.
+func (bsr *blockchainServerRepository) adminOnly(w http.ResponseWriter, req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return true
+		}
+	}
+	log.Printf("ERROR: admin request from %s", req.RemoteAddr)
+	w.WriteHeader(http.StatusForbidden)
+	io.WriteString(w, string(utils.JsonStatus("fail")))
+	return false
+}
+
 func (bsr *blockchainServerRepository) GetChain(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
@@ -87,8 +192,14 @@ func (bsr *blockchainServerRepository) Transactions(bs *entity.BlockchainServer,
 			io.WriteString(w, string(utils.JsonStatus("fail")))
 			return
 		}
+		signature, err := utils.SignatureFromString(*t.Signature)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
 		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		signature := utils.SignatureFromString(*t.Signature)
 		bc := bsr.GetBlockchain(bs, bcr, br, wr)
 		isCreated := bcr.CreateTransaction(bc, *t.SenderBlockchainAddress,
 			*t.RecipientBlockchainAddress, *t.Value, publicKey, signature)
@@ -103,24 +214,46 @@ func (bsr *blockchainServerRepository) Transactions(bs *entity.BlockchainServer,
 			m = utils.JsonStatus("success")
 		}
 		io.WriteString(w, string(m))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// PeerTransactions receives transactions relayed by neighbors and drops the
+// transactions of a block a neighbor announces from the pool.
+func (bsr *blockchainServerRepository) PeerTransactions(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	bc := bsr.GetBlockchain(bs, bcr, br, wr)
+	peer, ok := bsr.authenticatePeer(bc, bcr, w, req)
+	if !ok {
+		return
+	}
+	switch req.Method {
 	case http.MethodPut:
 		decoder := json.NewDecoder(req.Body)
 		var t request.TransactionRequest
 		err := decoder.Decode(&t)
 		if err != nil {
 			log.Printf("ERROR: %v", err)
+			bcr.Misbehaving(bc, peer, MISBEHAVIOR_MALFORMED, "malformed transaction")
 			io.WriteString(w, string(utils.JsonStatus("fail")))
 			return
 		}
 		if !t.Validate() {
 			log.Println("ERROR: missing field(s)")
+			bcr.Misbehaving(bc, peer, MISBEHAVIOR_MALFORMED, "malformed transaction")
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		signature, err := utils.SignatureFromString(*t.Signature)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			bcr.Misbehaving(bc, peer, MISBEHAVIOR_MALFORMED, "malformed transaction")
 			io.WriteString(w, string(utils.JsonStatus("fail")))
 			return
 		}
 		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		signature := utils.SignatureFromString(*t.Signature)
-		bc := bsr.GetBlockchain(bs, bcr, br, wr)
-		isUpdated := bcr.AddTransaction(bc, *t.SenderBlockchainAddress,
+		isUpdated := bcr.ReceiveTransaction(bc, peer, *t.SenderBlockchainAddress,
 			*t.RecipientBlockchainAddress, *t.Value, publicKey, signature)
 
 		w.Header().Add("Content-Type", "application/json")
@@ -132,9 +265,162 @@ func (bsr *blockchainServerRepository) Transactions(bs *entity.BlockchainServer,
 			m = utils.JsonStatus("success")
 		}
 		io.WriteString(w, string(m))
+	case http.MethodDelete:
+		data, err := ioutil.ReadAll(io.LimitReader(req.Body, p2p.MAX_PAYLOAD_SIZE))
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		blocks, err := p2p.DecodeBlocks(data)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			bcr.Misbehaving(bc, peer, MISBEHAVIOR_MALFORMED, "malformed block")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		for _, b := range blocks {
+			if !bcr.RemoveBlockTransactions(bc, b) {
+				log.Printf("block from %s does not seal onto our tip, pool kept", peer)
+			}
+		}
+		io.WriteString(w, string(utils.JsonStatus("success")))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bsr *blockchainServerRepository) Inventory(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		bc := bsr.GetBlockchain(bs, bcr, br, wr)
+		peer, ok := bsr.authenticatePeer(bc, bcr, w, req)
+		if !ok {
+			return
+		}
+		decoder := json.NewDecoder(req.Body)
+		var ir request.InventoryRequest
+		err := decoder.Decode(&ir)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			bcr.Misbehaving(bc, peer, MISBEHAVIOR_MALFORMED, "malformed inventory")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if !ir.Validate() {
+			log.Println("ERROR: missing field(s)")
+			bcr.Misbehaving(bc, peer, MISBEHAVIOR_MALFORMED, "malformed inventory")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		hashes, ok := utils.HashesFromStrings(*ir.Transactions)
+		if !ok {
+			log.Println("ERROR: invalid transaction hash")
+			bcr.Misbehaving(bc, peer, MISBEHAVIOR_MALFORMED, "malformed inventory")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		missing := bcr.MissingTransactions(bc, hashes)
+
+		m, _ := json.Marshal(&response.InventoryResponse{Transactions: utils.HashesToStrings(missing)})
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bsr *blockchainServerRepository) Handshake(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		bc := bsr.GetBlockchain(bs, bcr, br, wr)
+		nodeID, ok := bsr.authenticatePeer(bc, bcr, w, req)
+		if !ok {
+			return
+		}
+		decoder := json.NewDecoder(req.Body)
+		var hr request.HandshakeRequest
+		err := decoder.Decode(&hr)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			bcr.Misbehaving(bc, nodeID, MISBEHAVIOR_MALFORMED, "malformed handshake")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		remote, ok := PeerFromRequest(&hr)
+		if !ok {
+			log.Println("ERROR: missing field(s)")
+			bcr.Misbehaving(bc, nodeID, MISBEHAVIOR_MALFORMED, "malformed handshake")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		remote.NodeID = nodeID
+		local, accepted := bcr.AcceptHandshake(bc, remote)
+
+		w.Header().Add("Content-Type", "application/json")
+		if !accepted {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		m, _ := json.Marshal(NewPeerResponse(local))
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bsr *blockchainServerRepository) AdminPeers(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	if !bsr.adminOnly(w, req) {
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		bc := bsr.GetBlockchain(bs, bcr, br, wr)
+		peers := bcr.Peers(bc)
+		pr := make([]*response.PeerResponse, 0, len(peers))
+		for _, p := range peers {
+			pr = append(pr, NewPeerResponse(p))
+		}
+		m, _ := json.Marshal(&response.PeersResponse{Peers: pr, Length: len(pr)})
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bsr *blockchainServerRepository) AdminBans(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	if !bsr.adminOnly(w, req) {
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		bc := bsr.GetBlockchain(bs, bcr, br, wr)
+		bans := bcr.Bans(bc)
+		brs := make([]*response.BanResponse, 0, len(bans))
+		for _, b := range bans {
+			brs = append(brs, &response.BanResponse{Address: b.Address, Until: b.Until, Reason: b.Reason})
+		}
+		m, _ := json.Marshal(&response.BansResponse{Bans: brs, Length: len(brs)})
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
 	case http.MethodDelete:
 		bc := bsr.GetBlockchain(bs, bcr, br, wr)
-		bcr.ClearTransactionPool(bc)
+		bcr.ClearBans(bc, req.URL.Query().Get("address"))
+
+		w.Header().Add("Content-Type", "application/json")
 		io.WriteString(w, string(utils.JsonStatus("success")))
 	default:
 		log.Println("ERROR: Invalid HTTP Method")
@@ -143,6 +429,9 @@ func (bsr *blockchainServerRepository) Transactions(bs *entity.BlockchainServer,
 }
 
 func (bsr *blockchainServerRepository) Mine(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	if !bsr.adminOnly(w, req) {
+		return
+	}
 	switch req.Method {
 	case http.MethodGet:
 		bc := bsr.GetBlockchain(bs, bcr, br, wr)
@@ -163,9 +452,15 @@ func (bsr *blockchainServerRepository) Mine(bs *entity.BlockchainServer, bcr rep
 	}
 }
 
+// StartMine and StopMine are idempotent: starting a running miner or
+// stopping a stopped one succeeds without changing anything. Like Mine they
+// are only served to the local machine.
 func (bsr *blockchainServerRepository) StartMine(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	if !bsr.adminOnly(w, req) {
+		return
+	}
 	switch req.Method {
-	case http.MethodGet:
+	case http.MethodGet, http.MethodPost:
 		bc := bsr.GetBlockchain(bs, bcr, br, wr)
 		bcr.StartMining(bc, br)
 
@@ -178,6 +473,191 @@ func (bsr *blockchainServerRepository) StartMine(bs *entity.BlockchainServer, bc
 	}
 }
 
+func (bsr *blockchainServerRepository) StopMine(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	if !bsr.adminOnly(w, req) {
+		return
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodPost:
+		bc := bsr.GetBlockchain(bs, bcr, br, wr)
+		bcr.StopMining(bc)
+
+		m := utils.JsonStatus("success")
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bsr *blockchainServerRepository) MineStatus(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		bc := bsr.GetBlockchain(bs, bcr, br, wr)
+		status := bcr.MiningStatus(bc)
+		sr := &response.MiningStatusResponse{
+			State:                status.State,
+			Hashrate:             status.Hashrate,
+			NextRun:              status.NextRun,
+			IntervalSec:          status.Interval.Seconds(),
+			OnlyWithTransactions: status.OnlyWithTransactions,
+		}
+		if status.LastBlock != nil {
+			sr.LastBlock = &response.MinedBlockResponse{
+				Hash:      fmt.Sprintf("%x", br.Hash(status.LastBlock)),
+				Height:    status.LastBlockHeight,
+				Timestamp: status.LastBlock.Timestamp,
+				Nonce:     status.LastBlock.Nonce,
+			}
+		}
+		m, _ := json.Marshal(sr)
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// Metrics serves node counters in the Prometheus text format.
+func (bsr *blockchainServerRepository) Metrics(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		bc := bsr.GetBlockchain(bs, bcr, br, wr)
+		m := bcr.Metrics(bc)
+		w.Header().Add("Content-Type", "text/plain; version=0.0.4")
+		metric := func(name string, kind string, help string, value interface{}) {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+		}
+		metric("blockchain_height", "gauge", "Height of the chain tip.", m.Height)
+		metric("blockchain_cumulative_work", "gauge", "Weight of the chain under the consensus engine.", m.CumulativeWork)
+		metric("blockchain_peers", "gauge", "Connected neighbors.", m.Peers)
+		metric("blockchain_transaction_pool_size", "gauge", "Transactions waiting to be mined.", m.Transactions)
+		metric("blockchain_hashrate", "gauge", "Hashes per second of the last proof of work.", m.Hashrate)
+		metric("blockchain_deep_reorgs_total", "counter", "Reorgs attempted at or beyond the alert depth.", m.DeepReorgs)
+		metric("blockchain_refused_reorgs_total", "counter", "Reorgs refused for dropping final blocks.", m.RefusedReorgs)
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func NewTransactionResponse(t *entity.Transaction) *response.TransactionResponse {
+	return &response.TransactionResponse{
+		SenderBlockchainAddress:    t.SenderBlockchainAddress,
+		RecipientBlockchainAddress: t.RecipientBlockchainAddress,
+		Value:                      t.Value,
+	}
+}
+
+// MiningTemplate hands out work to external miners. The coinbase pays the
+// address query parameter, or the node's own address when it is missing.
+func (bsr *blockchainServerRepository) MiningTemplate(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		bc := bsr.GetBlockchain(bs, bcr, br, wr)
+		if bcr.Consensus().Name() != CONSENSUS_POW {
+			log.Printf("ERROR: no external mining under %s", bcr.Consensus().Name())
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rewardAddress := req.URL.Query().Get("address")
+		if rewardAddress == "" {
+			rewardAddress = bc.BlockchainAddress
+		}
+		tmpl := bcr.MiningTemplate(bc, br, rewardAddress)
+		target, _ := utils.CompactToBig(tmpl.Block.Bits)
+		tr := &response.MiningTemplateResponse{
+			TemplateID:   fmt.Sprintf("%x", tmpl.ID),
+			Height:       tmpl.Height,
+			PreviousHash: fmt.Sprintf("%x", tmpl.Block.PreviousHash),
+			Bits:         fmt.Sprintf("%08x", tmpl.Block.Bits),
+			Transactions: make([]*response.TransactionResponse, 0, len(tmpl.Block.Transactions)),
+			Coinbase:     NewTransactionResponse(tmpl.Coinbase),
+			HeaderPrefix: hex.EncodeToString(tmpl.HeaderPrefix),
+			HeaderSuffix: hex.EncodeToString(tmpl.HeaderSuffix),
+			Timestamp:    tmpl.Block.Timestamp,
+		}
+		if target != nil {
+			tr.Target = fmt.Sprintf("%064x", target)
+		}
+		for _, t := range tmpl.Block.Transactions {
+			tr.Transactions = append(tr.Transactions, NewTransactionResponse(t))
+		}
+		m, _ := json.Marshal(tr)
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bsr *blockchainServerRepository) MiningSubmit(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		w.Header().Add("Content-Type", "application/json")
+		var sr request.MiningSubmitRequest
+		if err := json.NewDecoder(req.Body).Decode(&sr); err != nil || !sr.Validate() {
+			log.Println("ERROR: missing field(s)")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		ids, ok := utils.HashesFromStrings([]string{*sr.TemplateID})
+		if !ok {
+			log.Println("ERROR: malformed template_id")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		bc := bsr.GetBlockchain(bs, bcr, br, wr)
+		if !bcr.SubmitBlock(bc, br, ids[0], *sr.Nonce) {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(utils.JsonStatus("success")))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (bsr *blockchainServerRepository) PoolWorkers(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		bc := bsr.GetBlockchain(bs, bcr, br, wr)
+		workers, ok := bcr.PoolWorkers(bc)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		pr := make([]*response.PoolWorkerResponse, 0, len(workers))
+		for _, pw := range workers {
+			pr = append(pr, &response.PoolWorkerResponse{
+				Address:     pw.Address,
+				Shares:      pw.Shares,
+				TotalShares: pw.TotalShares,
+				LastShare:   pw.LastShare,
+				Owed:        pw.Owed,
+				Paid:        pw.Paid,
+			})
+		}
+		m, _ := json.Marshal(&response.PoolWorkersResponse{Workers: pr, Length: len(pr)})
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Println("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
 func (bsr *blockchainServerRepository) Amount(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
@@ -197,10 +677,82 @@ func (bsr *blockchainServerRepository) Amount(bs *entity.BlockchainServer, bcr r
 	}
 }
 
+func transferResponses(transfers []*entity.AddressTransfer) []*response.TransferResponse {
+	trs := make([]*response.TransferResponse, 0, len(transfers))
+	for _, t := range transfers {
+		trs = append(trs, &response.TransferResponse{
+			ID:            fmt.Sprintf("%x", t.ID),
+			Direction:     t.Direction,
+			Counterparty:  t.Counterparty,
+			Amount:        t.Amount,
+			Height:        t.Height,
+			Timestamp:     t.Timestamp,
+			Confirmations: t.Confirmations,
+			Pending:       t.Pending,
+		})
+	}
+	return trs
+}
+
+// History pages through the transfers of blockchain_address with offset
+// and limit, using the node's address index.
+func (bsr *blockchainServerRepository) History(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		q := req.URL.Query()
+		blockchainAddress := q.Get("blockchain_address")
+		offset, err1 := strconv.Atoi(q.Get("offset"))
+		limit, err2 := strconv.Atoi(q.Get("limit"))
+		if blockchainAddress == "" || (q.Get("offset") != "" && err1 != nil) || (q.Get("limit") != "" && err2 != nil) {
+			log.Println("ERROR: invalid history query")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		bc := bsr.GetBlockchain(bs, bcr, br, wr)
+		h := bcr.AddressHistory(bc, blockchainAddress, offset, limit)
+		m, _ := json.Marshal(&response.HistoryResponse{
+			BlockchainAddress: h.BlockchainAddress,
+			Transfers:         transferResponses(h.Transfers),
+			Pending:           transferResponses(h.Pending),
+			Offset:            h.Offset,
+			Limit:             h.Limit,
+			Total:             h.Total,
+			Height:            h.Height,
+		})
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// PeerChain serves the chain to neighbors in the binary block encoding,
+// which keeps the signatures the block hashes commit to.
+func (bsr *blockchainServerRepository) PeerChain(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		bc := bsr.GetBlockchain(bs, bcr, br, wr)
+		if _, ok := bsr.authenticatePeer(bc, bcr, w, req); !ok {
+			return
+		}
+		w.Header().Add("Content-Type", "application/octet-stream")
+		w.Write(p2p.EncodeBlocks(bcr.Chain(bc)))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
 func (bsr *blockchainServerRepository) Consensus(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository, w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodPut:
 		bc := bsr.GetBlockchain(bs, bcr, br, wr)
+		if _, ok := bsr.authenticatePeer(bc, bcr, w, req); !ok {
+			return
+		}
 		replaced := bcr.ResolveConflicts(bc, br)
 
 		w.Header().Add("Content-Type", "application/json")
@@ -218,6 +770,9 @@ func (bsr *blockchainServerRepository) Consensus(bs *entity.BlockchainServer, bc
 func (bsr *blockchainServerRepository) Run(bs *entity.BlockchainServer, bcr repository.BlockchainRepository, br repository.BlockRepository, wr wdr.WalletRepository) {
 	bc := bsr.GetBlockchain(bs, bcr, br, wr)
 	bcr.Run(bc, br)
+	if bs.MineOnStart {
+		bcr.StartMining(bc, br)
+	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		bsr.GetChain(bs, bcr, br, wr, w, req)
@@ -231,11 +786,76 @@ func (bsr *blockchainServerRepository) Run(bs *entity.BlockchainServer, bcr repo
 	http.HandleFunc("/mine/start", func(w http.ResponseWriter, req *http.Request) {
 		bsr.StartMine(bs, bcr, br, wr, w, req)
 	})
+	http.HandleFunc("/mine/stop", func(w http.ResponseWriter, req *http.Request) {
+		bsr.StopMine(bs, bcr, br, wr, w, req)
+	})
+	http.HandleFunc("/mine/status", func(w http.ResponseWriter, req *http.Request) {
+		bsr.MineStatus(bs, bcr, br, wr, w, req)
+	})
+	http.HandleFunc("/mining/template", func(w http.ResponseWriter, req *http.Request) {
+		bsr.MiningTemplate(bs, bcr, br, wr, w, req)
+	})
+	http.HandleFunc("/mining/submit", func(w http.ResponseWriter, req *http.Request) {
+		bsr.MiningSubmit(bs, bcr, br, wr, w, req)
+	})
+	http.HandleFunc("/pool/workers", func(w http.ResponseWriter, req *http.Request) {
+		bsr.PoolWorkers(bs, bcr, br, wr, w, req)
+	})
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		bsr.Metrics(bs, bcr, br, wr, w, req)
+	})
 	http.HandleFunc("/amount", func(w http.ResponseWriter, req *http.Request) {
 		bsr.Amount(bs, bcr, br, wr, w, req)
 	})
-	http.HandleFunc("/consensus", func(w http.ResponseWriter, req *http.Request) {
-		bsr.Consensus(bs, bcr, br, wr, w, req)
+
+	http.HandleFunc("/history", func(w http.ResponseWriter, req *http.Request) {
+		bsr.History(bs, bcr, br, wr, w, req)
+	})
+	http.HandleFunc("/admin/peers", func(w http.ResponseWriter, req *http.Request) {
+		bsr.AdminPeers(bs, bcr, br, wr, w, req)
 	})
-	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(bsr.Port(bs))), nil))
+	http.HandleFunc("/admin/bans", func(w http.ResponseWriter, req *http.Request) {
+		bsr.AdminBans(bs, bcr, br, wr, w, req)
+	})
+
+	// Neighbors only reach these when they talk to us over HTTP; with the
+	// TCP transport the HTTP server is the client API alone.
+	if bs.PeerTransport == "http" {
+		http.HandleFunc("/p2p/handshake", func(w http.ResponseWriter, req *http.Request) {
+			bsr.Handshake(bs, bcr, br, wr, w, req)
+		})
+		http.HandleFunc("/p2p/inv", func(w http.ResponseWriter, req *http.Request) {
+			bsr.Inventory(bs, bcr, br, wr, w, req)
+		})
+		http.HandleFunc("/p2p/transactions", func(w http.ResponseWriter, req *http.Request) {
+			bsr.PeerTransactions(bs, bcr, br, wr, w, req)
+		})
+		http.HandleFunc("/p2p/chain", func(w http.ResponseWriter, req *http.Request) {
+			bsr.PeerChain(bs, bcr, br, wr, w, req)
+		})
+		http.HandleFunc("/p2p/consensus", func(w http.ResponseWriter, req *http.Request) {
+			bsr.Consensus(bs, bcr, br, wr, w, req)
+		})
+	}
+	// Stop a running proof of work before exiting instead of leaving it to
+	// be killed halfway through a block.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("action=shutdown, signal=%v", sig)
+		bcr.Shutdown(bsr.GetBlockchain(bs, bcr, br, wr))
+		os.Exit(0)
+	}()
+
+	address := "0.0.0.0:" + strconv.Itoa(int(bsr.Port(bs)))
+	if bs.TLSCertFile == "" {
+		log.Fatal(http.ListenAndServe(address, nil))
+	}
+	tlsConfig, err := utils.ServerTLSConfig(bs.TLSCertFile, bs.TLSKeyFile, bs.TLSCAFile)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	server := &http.Server{Addr: address, TLSConfig: tlsConfig}
+	log.Fatal(server.ListenAndServeTLS("", ""))
 }
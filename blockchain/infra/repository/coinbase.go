@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"log"
+
+	"go-blockchain/blockchain/domain/entity"
+)
+
+const (
+	// The block reward starts at MINING_REWARD and halves every
+	// HALVING_INTERVAL blocks.
+	HALVING_INTERVAL = 100000
+	// Coinbase rewards can be spent once COINBASE_MATURITY blocks have been
+	// built on top of theirs.
+	COINBASE_MATURITY = 0
+)
+
+// BlockReward is what the coinbase of the block at height must pay. An
+// interval of 0 never halves.
+func BlockReward(bc *entity.Blockchain, height int) float32 {
+	reward := float32(MINING_REWARD)
+	if bc.HalvingInterval <= 0 {
+		return reward
+	}
+	for halvings := height / bc.HalvingInterval; halvings > 0 && reward > 0; halvings-- {
+		reward /= 2
+	}
+	return reward
+}
+
+// validCoinbase checks that b starts with its only coinbase and that the
+// coinbase pays exactly the reward for b.Height.
+func validCoinbase(bc *entity.Blockchain, b *entity.Block) bool {
+	if len(b.Transactions) == 0 {
+		log.Printf("ERROR: block %d has no coinbase", b.Height)
+		return false
+	}
+	coinbase := b.Transactions[0]
+	if coinbase.SenderBlockchainAddress != MINING_SENDER || coinbase.Signature != nil {
+		log.Printf("ERROR: block %d does not start with a coinbase", b.Height)
+		return false
+	}
+	if reward := BlockReward(bc, b.Height); coinbase.Value != reward {
+		log.Printf("ERROR: block %d pays %v instead of %v", b.Height, coinbase.Value, reward)
+		return false
+	}
+	for _, t := range b.Transactions[1:] {
+		if t.SenderBlockchainAddress == MINING_SENDER {
+			log.Printf("ERROR: block %d has more than one coinbase", b.Height)
+			return false
+		}
+	}
+	return true
+}
+
+// ledger tracks spendable balances along a chain. Coinbase rewards are held
+// back until maturity blocks have been built on top of theirs.
+type ledger struct {
+	maturity int
+	balances map[string]float32
+	immature []immatureReward
+}
+
+type immatureReward struct {
+	height   int
+	coinbase *entity.Transaction
+}
+
+func newLedger(maturity int) *ledger {
+	return &ledger{maturity: maturity, balances: make(map[string]float32)}
+}
+
+// chainLedger is the ledger after every block of chain, as seen by a block
+// at height len(chain).
+func chainLedger(bc *entity.Blockchain, chain []*entity.Block) *ledger {
+	l := newLedger(bc.CoinbaseMaturity)
+	for height, b := range chain {
+		if height > 0 {
+			l.apply(b, height)
+		}
+	}
+	l.mature(len(chain))
+	return l
+}
+
+// mature releases the rewards a block at height may spend.
+func (l *ledger) mature(height int) {
+	i := 0
+	for ; i < len(l.immature) && l.immature[i].height+l.maturity < height; i++ {
+		coinbase := l.immature[i].coinbase
+		l.balances[coinbase.RecipientBlockchainAddress] += coinbase.Value
+	}
+	l.immature = l.immature[i:]
+}
+
+// spend moves t's value if it is positive and its sender can afford it.
+func (l *ledger) spend(t *entity.Transaction) bool {
+	if !(t.Value > 0) || l.balances[t.SenderBlockchainAddress] < t.Value {
+		return false
+	}
+	l.balances[t.SenderBlockchainAddress] -= t.Value
+	l.balances[t.RecipientBlockchainAddress] += t.Value
+	return true
+}
+
+// apply adds the block at height, whose coinbase has been checked, and
+// reports whether every other transaction was spendable.
+func (l *ledger) apply(b *entity.Block, height int) bool {
+	l.mature(height)
+	ok := true
+	for i, t := range b.Transactions {
+		if i == 0 && t.SenderBlockchainAddress == MINING_SENDER {
+			continue
+		}
+		if !l.spend(t) {
+			log.Printf("ERROR: block %d spends more than %s can", height, t.SenderBlockchainAddress)
+			ok = false
+		}
+	}
+	if len(b.Transactions) > 0 && b.Transactions[0].SenderBlockchainAddress == MINING_SENDER {
+		l.immature = append(l.immature, immatureReward{height: height, coinbase: b.Transactions[0]})
+	}
+	return ok
+}
+
+// SpendableAmount is what address can still send: its balance without
+// coinbase rewards that have not matured and without what it already has
+// in the transaction pool.
+func (bcr *blockchainRepository) SpendableAmount(bc *entity.Blockchain, blockchainAddress string) float32 {
+	l := chainLedger(bc, bc.Chain)
+	for _, t := range bc.TransactionPool {
+		l.spend(t)
+	}
+	return l.balances[blockchainAddress]
+}
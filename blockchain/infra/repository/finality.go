@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/utils"
+)
+
+const (
+	// Blocks buried deeper than FINALITY_DEPTH are never reorganized away.
+	FINALITY_DEPTH = 100
+	// Reorgs of REORG_ALERT_DEPTH blocks or more are logged as alerts.
+	REORG_ALERT_DEPTH = 6
+)
+
+// LoadCheckpoints reads one "<height> <block hash>" per line. Blank lines and
+// lines starting with # are skipped.
+func LoadCheckpoints(path string) (map[int][32]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	checkpoints := make(map[int][32]byte)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid checkpoint %q in %s", line, path)
+		}
+		height, err := strconv.Atoi(fields[0])
+		hashes, ok := utils.HashesFromStrings(fields[1:])
+		if err != nil || height < 0 || !ok {
+			return nil, fmt.Errorf("invalid checkpoint %q in %s", line, path)
+		}
+		checkpoints[height] = hashes[0]
+	}
+	return checkpoints, scanner.Err()
+}
+
+// validCheckpoints reports whether chain has the checkpointed hash at every
+// checkpoint height it reaches.
+func validCheckpoints(bc *entity.Blockchain, chain []*entity.Block) bool {
+	for height, hash := range bc.Checkpoints {
+		if height < len(chain) && chain[height].Hash() != hash {
+			log.Printf("ERROR: chain does not match checkpoint %d %x", height, hash)
+			return false
+		}
+	}
+	return true
+}
+
+// reorgDepth is the number of blocks of current that adopting chain would
+// drop.
+func reorgDepth(current []*entity.Block, chain []*entity.Block) int {
+	common := 0
+	for common < len(current) && common < len(chain) && current[common].Hash() == chain[common].Hash() {
+		common += 1
+	}
+	return len(current) - common
+}
+
+// allowReorg reports whether a reorg of depth blocks, offered by peer, keeps
+// every final block. Deep attempts are logged as alerts and counted either
+// way.
+func (bcr *blockchainRepository) allowReorg(bc *entity.Blockchain, peer string, depth int) bool {
+	allowed := bc.FinalityDepth <= 0 || depth <= bc.FinalityDepth
+	deep := bc.ReorgAlertDepth > 0 && depth >= bc.ReorgAlertDepth
+	if !allowed || deep {
+		bc.MuxReorgs.Lock()
+		if deep {
+			bc.DeepReorgs += 1
+		}
+		if !allowed {
+			bc.RefusedReorgs += 1
+		}
+		bc.MuxReorgs.Unlock()
+	}
+	if deep {
+		status := "allowed"
+		if !allowed {
+			status = "refused"
+		}
+		log.Printf("ALERT: action=reorg, peer=%s, depth=%d, finality_depth=%d, status=%s",
+			peer, depth, bc.FinalityDepth, status)
+	}
+	return allowed
+}
+
+func (bcr *blockchainRepository) Metrics(bc *entity.Blockchain) *entity.Metrics {
+	m := &entity.Metrics{}
+	bc.Mux.Lock()
+	m.Height = len(bc.Chain) - 1
+	m.CumulativeWork = bcr.CumulativeWork(bc.Chain)
+	m.Transactions = len(bc.TransactionPool)
+	bc.Mux.Unlock()
+	m.Peers = len(bcr.Peers(bc))
+	bc.MuxHashrate.Lock()
+	m.Hashrate = bc.Hashrate
+	bc.MuxHashrate.Unlock()
+	bc.MuxReorgs.Lock()
+	m.DeepReorgs = bc.DeepReorgs
+	m.RefusedReorgs = bc.RefusedReorgs
+	bc.MuxReorgs.Unlock()
+	return m
+}
@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/blockchain/domain/repository"
+	"go-blockchain/blockchain/infra/http/request"
+	"go-blockchain/blockchain/infra/http/response"
+	"go-blockchain/blockchain/infra/p2p"
+	"go-blockchain/utils"
+)
+
+const (
+	PEER_TIMEOUT_SEC    = 5
+	NODE_ADDRESS_HEADER = "X-Node-Address"
+)
+
+type httpPeerTransport struct {
+	scheme string
+	client *http.Client
+}
+
+// NewHTTPPeerTransport talks plain HTTP to neighbors, or HTTPS when
+// tlsConfig is given.
+func NewHTTPPeerTransport(tlsConfig *tls.Config) repository.PeerTransport {
+	pt := &httpPeerTransport{scheme: "http", client: &http.Client{Timeout: time.Second * PEER_TIMEOUT_SEC}}
+	if tlsConfig != nil {
+		pt.scheme = "https"
+		pt.client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	return pt
+}
+
+func (pt *httpPeerTransport) url(peer string, path string) string {
+	return fmt.Sprintf("%s://%s%s", pt.scheme, peer, path)
+}
+
+func (pt *httpPeerTransport) do(bc *entity.Blockchain, method string, endpoint string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, err
+	}
+	req.Header.Set(NODE_ADDRESS_HEADER, bc.Address)
+	if err := SignPeerRequest(bc, req, body); err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, err
+	}
+	resp, err := pt.client.Do(req)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, err
+	}
+	log.Printf("%v", resp)
+	return resp, nil
+}
+
+func (pt *httpPeerTransport) Handshake(bc *entity.Blockchain, peer string, local *entity.Peer) (*entity.Peer, bool) {
+	m, _ := json.Marshal(NewPeerResponse(local))
+	resp, err := pt.do(bc, http.MethodPost, pt.url(peer, "/p2p/handshake"), m)
+	if err != nil {
+		return nil, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+	var hr request.HandshakeRequest
+	if err := json.NewDecoder(resp.Body).Decode(&hr); err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, false
+	}
+	return PeerFromRequest(&hr)
+}
+
+// RemoteHost is the host a request came from. Peers are only told apart
+// by it until their signature is verified.
+func RemoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func NewPeerResponse(p *entity.Peer) *response.PeerResponse {
+	return &response.PeerResponse{
+		ProtocolVersion: p.ProtocolVersion,
+		NetworkID:       p.NetworkID,
+		GenesisHash:     fmt.Sprintf("%x", p.GenesisHash),
+		Height:          p.Height,
+		CumulativeWork:  p.CumulativeWork,
+		Address:         p.Address,
+		NodeID:          p.NodeID,
+		LastSeen:        p.LastSeen,
+	}
+}
+
+func PeerFromRequest(hr *request.HandshakeRequest) (*entity.Peer, bool) {
+	if !hr.Validate() {
+		return nil, false
+	}
+	hashes, ok := utils.HashesFromStrings([]string{*hr.GenesisHash})
+	if !ok {
+		return nil, false
+	}
+	nodeID := ""
+	if hr.NodeID != nil {
+		nodeID = *hr.NodeID
+	}
+	return &entity.Peer{
+		ProtocolVersion: *hr.ProtocolVersion,
+		NetworkID:       *hr.NetworkID,
+		GenesisHash:     hashes[0],
+		Height:          *hr.Height,
+		CumulativeWork:  *hr.CumulativeWork,
+		Address:         *hr.Address,
+		NodeID:          nodeID,
+	}, true
+}
+
+// AnnounceBlock sends the peer a block that was created so it can drop the
+// transactions that went into it.
+func (pt *httpPeerTransport) AnnounceBlock(bc *entity.Blockchain, peer string, b *entity.Block) bool {
+	resp, err := pt.do(bc, http.MethodDelete, pt.url(peer, "/p2p/transactions"), p2p.EncodeBlocks([]*entity.Block{b}))
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func (pt *httpPeerTransport) AnnounceTransactions(bc *entity.Blockchain, peer string, hashes [][32]byte) ([][32]byte, error) {
+	inv := utils.HashesToStrings(hashes)
+	m, _ := json.Marshal(&request.InventoryRequest{Transactions: &inv})
+	resp, err := pt.do(bc, http.MethodPost, pt.url(peer, "/p2p/inv"), m)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("inventory refused by %s: %s", peer, resp.Status)
+	}
+	var ir response.InventoryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ir); err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, err
+	}
+	missing, ok := utils.HashesFromStrings(ir.Transactions)
+	if !ok {
+		return nil, fmt.Errorf("invalid inventory from %s", peer)
+	}
+	return missing, nil
+}
+
+func (pt *httpPeerTransport) SendTransaction(bc *entity.Blockchain, peer string, t *entity.Transaction,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
+	publicKeyStr := fmt.Sprintf("%064x%064x", senderPublicKey.X.Bytes(),
+		senderPublicKey.Y.Bytes())
+	signatureStr := s.String()
+	bt := &request.TransactionRequest{
+		SenderBlockchainAddress:    &t.SenderBlockchainAddress,
+		RecipientBlockchainAddress: &t.RecipientBlockchainAddress,
+		SenderPublicKey:            &publicKeyStr,
+		Value:                      &t.Value,
+		Signature:                  &signatureStr,
+	}
+	m, _ := json.Marshal(bt)
+	resp, err := pt.do(bc, http.MethodPut, pt.url(peer, "/p2p/transactions"), m)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func (pt *httpPeerTransport) RequestChain(bc *entity.Blockchain, peer string) ([]*entity.Block, bool) {
+	resp, err := pt.do(bc, http.MethodGet, pt.url(peer, "/p2p/chain"), nil)
+	if err != nil {
+		return nil, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, p2p.MAX_PAYLOAD_SIZE))
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, false
+	}
+	chain, err := p2p.DecodeBlocks(data)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, false
+	}
+	return chain, true
+}
+
+func (pt *httpPeerTransport) TriggerConsensus(bc *entity.Blockchain, peer string) bool {
+	resp, err := pt.do(bc, http.MethodPut, pt.url(peer, "/p2p/consensus"), nil)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/utils"
+)
+
+const (
+	NODE_ID_HEADER           = "X-Node-Id"
+	NODE_TIMESTAMP_HEADER    = "X-Node-Timestamp"
+	NODE_SIGNATURE_HEADER    = "X-Node-Signature"
+	PEER_MESSAGE_MAX_AGE_SEC = 30
+)
+
+// LoadIdentity reads the node's private key from path, creating a new one the
+// first time the node starts.
+func LoadIdentity(path string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		d := fmt.Sprintf("%064x\n", privateKey.D.Bytes())
+		if err := ioutil.WriteFile(path, []byte(d), 0600); err != nil {
+			return nil, err
+		}
+		return privateKey, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(b) != 32 {
+		return nil, fmt.Errorf("invalid identity key in %s", path)
+	}
+	privateKey := new(ecdsa.PrivateKey)
+	privateKey.Curve = elliptic.P256()
+	privateKey.D = new(big.Int).SetBytes(b)
+	privateKey.X, privateKey.Y = privateKey.Curve.ScalarBaseMult(b)
+	return privateKey, nil
+}
+
+// LoadAllowedPeers reads one node ID per line. Blank lines and lines starting
+// with # are skipped.
+func LoadAllowedPeers(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	allowed := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		allowed[strings.ToLower(line)] = true
+	}
+	return allowed, scanner.Err()
+}
+
+func NodeID(publicKey *ecdsa.PublicKey) string {
+	return fmt.Sprintf("%064x%064x", publicKey.X.Bytes(), publicKey.Y.Bytes())
+}
+
+func peerMessageHash(method string, path string, address string, timestamp int64, body []byte) [32]byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%d\n", method, path, address, timestamp)
+	h.Write(body)
+	var digest [32]byte
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
+
+// SignPeerRequest adds the node's identity and a signature over the method,
+// path, announced address, timestamp and body to req.
+func SignPeerRequest(bc *entity.Blockchain, req *http.Request, body []byte) error {
+	if bc.Identity == nil {
+		return nil
+	}
+	timestamp := time.Now().Unix()
+	h := peerMessageHash(req.Method, req.URL.Path, req.Header.Get(NODE_ADDRESS_HEADER), timestamp, body)
+	r, s, err := ecdsa.Sign(rand.Reader, bc.Identity, h[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set(NODE_ID_HEADER, NodeID(&bc.Identity.PublicKey))
+	req.Header.Set(NODE_TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(NODE_SIGNATURE_HEADER, (&utils.Signature{R: r, S: s}).String())
+	return nil
+}
+
+// VerifyPeerRequest checks the signature added by SignPeerRequest and returns
+// the sender's node ID. A signed request is accepted once, a replay within
+// PEER_MESSAGE_MAX_AGE_SEC is refused. body is put back on req so handlers
+// can decode it.
+func VerifyPeerRequest(bc *entity.Blockchain, req *http.Request) (string, bool) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return "", false
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	nodeID := strings.ToLower(req.Header.Get(NODE_ID_HEADER))
+	signature := req.Header.Get(NODE_SIGNATURE_HEADER)
+	if len(nodeID) != 128 || len(signature) != 128 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(nodeID + signature); err != nil {
+		return "", false
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get(NODE_TIMESTAMP_HEADER), 10, 64)
+	if err != nil {
+		return "", false
+	}
+	age := time.Now().Unix() - timestamp
+	if age > PEER_MESSAGE_MAX_AGE_SEC || age < -PEER_MESSAGE_MAX_AGE_SEC {
+		return "", false
+	}
+
+	publicKey := utils.PublicKeyFromString(nodeID)
+	if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return "", false
+	}
+	s, err := utils.SignatureFromString(signature)
+	if err != nil {
+		return "", false
+	}
+	h := peerMessageHash(req.Method, req.URL.Path, req.Header.Get(NODE_ADDRESS_HEADER), timestamp, body)
+	if !ecdsa.Verify(publicKey, h[:], s.R, s.S) {
+		return "", false
+	}
+	return nodeID, markPeerRequestSeen(bc, nodeID, timestamp, s)
+}
+
+// markPeerRequestSeen records the signed request of nodeID and reports
+// whether it is new. A request is keyed on its timestamp and R, which stays
+// the same when S is negated into the other valid signature of the message.
+// Entries are dropped once their timestamp is too old to pass the age check.
+func markPeerRequestSeen(bc *entity.Blockchain, nodeID string, timestamp int64, s *utils.Signature) bool {
+	bc.MuxSeenRequests.Lock()
+	defer bc.MuxSeenRequests.Unlock()
+
+	now := time.Now().Unix()
+	if bc.SeenPeerRequests == nil {
+		bc.SeenPeerRequests = make(map[string]map[string]int64)
+	}
+	for id, seen := range bc.SeenPeerRequests {
+		for k, ts := range seen {
+			if now-ts > PEER_MESSAGE_MAX_AGE_SEC {
+				delete(seen, k)
+			}
+		}
+		if len(seen) == 0 {
+			delete(bc.SeenPeerRequests, id)
+		}
+	}
+	seen, ok := bc.SeenPeerRequests[nodeID]
+	if !ok {
+		seen = make(map[string]int64)
+		bc.SeenPeerRequests[nodeID] = seen
+	}
+	key := fmt.Sprintf("%d/%x", timestamp, s.R)
+	if _, ok := seen[key]; ok {
+		return false
+	}
+	seen[key] = timestamp
+	return true
+}
+
+// IsAllowedPeer reports whether nodeID may talk to us. Without an allow-list
+// every node is allowed.
+func (bcr *blockchainRepository) IsAllowedPeer(bc *entity.Blockchain, nodeID string) bool {
+	if len(bc.AllowedPeers) == 0 {
+		return true
+	}
+	return bc.AllowedPeers[strings.ToLower(nodeID)]
+}
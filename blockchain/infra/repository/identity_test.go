@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/utils"
+)
+
+func TestVerifyPeerRequestRefusesReplay(t *testing.T) {
+	identity, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := &entity.Blockchain{Identity: identity}
+	receiver := &entity.Blockchain{}
+	body := []byte(`{"nonce":1}`)
+
+	req := httptest.NewRequest("POST", "/transactions", bytes.NewReader(body))
+	if err := SignPeerRequest(sender, req, body); err != nil {
+		t.Fatal(err)
+	}
+	resend := func(signature string) {
+		req.Body = httptest.NewRequest("POST", "/transactions", bytes.NewReader(body)).Body
+		req.Header.Set(NODE_SIGNATURE_HEADER, signature)
+	}
+
+	signature := req.Header.Get(NODE_SIGNATURE_HEADER)
+	if nodeID, ok := VerifyPeerRequest(receiver, req); !ok || nodeID != NodeID(&identity.PublicKey) {
+		t.Fatalf("first request refused")
+	}
+	resend(signature)
+	if _, ok := VerifyPeerRequest(receiver, req); ok {
+		t.Fatalf("replayed request accepted")
+	}
+
+	// (r, n-s) signs the same message, the replay is still caught.
+	s, err := utils.SignatureFromString(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	negated := &utils.Signature{R: s.R, S: new(big.Int).Sub(elliptic.P256().Params().N, s.S)}
+	resend(negated.String())
+	if _, ok := VerifyPeerRequest(receiver, req); ok {
+		t.Fatalf("replay with the negated signature accepted")
+	}
+
+	// Another node has its own cache, and takes the negated signature.
+	resend(negated.String())
+	if _, ok := VerifyPeerRequest(&entity.Blockchain{}, req); !ok {
+		t.Fatalf("request refused by a node that has not seen it")
+	}
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"sync"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/blockchain/domain/repository"
+	"go-blockchain/utils"
+)
+
+// MemoryPeerTransport connects blockchains living in the same process.
+// Every node is registered under the address its neighbors know it by, so
+// several nodes can be wired together without opening sockets.
+type MemoryPeerTransport struct {
+	mux   sync.Mutex
+	nodes map[string]*memoryPeer
+}
+
+type memoryPeer struct {
+	bc  *entity.Blockchain
+	bcr repository.BlockchainRepository
+	br  repository.BlockRepository
+}
+
+func NewMemoryPeerTransport() *MemoryPeerTransport {
+	return &MemoryPeerTransport{nodes: make(map[string]*memoryPeer)}
+}
+
+func (mt *MemoryPeerTransport) Register(address string, bc *entity.Blockchain,
+	bcr repository.BlockchainRepository, br repository.BlockRepository) {
+	mt.mux.Lock()
+	defer mt.mux.Unlock()
+	mt.nodes[address] = &memoryPeer{bc: bc, bcr: bcr, br: br}
+}
+
+func (mt *MemoryPeerTransport) Unregister(address string) {
+	mt.mux.Lock()
+	defer mt.mux.Unlock()
+	delete(mt.nodes, address)
+}
+
+func (mt *MemoryPeerTransport) node(peer string) (*memoryPeer, bool) {
+	mt.mux.Lock()
+	defer mt.mux.Unlock()
+	p, ok := mt.nodes[peer]
+	return p, ok
+}
+
+func (mt *MemoryPeerTransport) Handshake(bc *entity.Blockchain, peer string, local *entity.Peer) (*entity.Peer, bool) {
+	p, ok := mt.node(peer)
+	if !ok {
+		return nil, false
+	}
+	remote := *local
+	return p.bcr.AcceptHandshake(p.bc, &remote)
+}
+
+func (mt *MemoryPeerTransport) AnnounceBlock(bc *entity.Blockchain, peer string, b *entity.Block) bool {
+	p, ok := mt.node(peer)
+	if !ok {
+		return false
+	}
+	p.bcr.RemoveBlockTransactions(p.bc, b)
+	return true
+}
+
+func (mt *MemoryPeerTransport) AnnounceTransactions(bc *entity.Blockchain, peer string, hashes [][32]byte) ([][32]byte, error) {
+	p, ok := mt.node(peer)
+	if !ok {
+		return nil, fmt.Errorf("unknown peer %s", peer)
+	}
+	return p.bcr.MissingTransactions(p.bc, hashes), nil
+}
+
+func (mt *MemoryPeerTransport) SendTransaction(bc *entity.Blockchain, peer string, t *entity.Transaction,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
+	p, ok := mt.node(peer)
+	if !ok {
+		return false
+	}
+	return p.bcr.ReceiveTransaction(p.bc, bc.Address, t.SenderBlockchainAddress,
+		t.RecipientBlockchainAddress, t.Value, senderPublicKey, s)
+}
+
+func (mt *MemoryPeerTransport) RequestChain(bc *entity.Blockchain, peer string) ([]*entity.Block, bool) {
+	p, ok := mt.node(peer)
+	if !ok {
+		return nil, false
+	}
+	// Blocks are copied under the remote's lock, as the wire transports do
+	// by encoding them, so the two nodes never share one.
+	p.bc.Mux.Lock()
+	defer p.bc.Mux.Unlock()
+	chain := make([]*entity.Block, 0, len(p.bc.Chain))
+	for _, b := range p.bc.Chain {
+		c := *b
+		c.Transactions = make([]*entity.Transaction, 0, len(b.Transactions))
+		for _, t := range b.Transactions {
+			ct := *t
+			c.Transactions = append(c.Transactions, &ct)
+		}
+		chain = append(chain, &c)
+	}
+	return chain, true
+}
+
+func (mt *MemoryPeerTransport) TriggerConsensus(bc *entity.Blockchain, peer string) bool {
+	p, ok := mt.node(peer)
+	if !ok {
+		return false
+	}
+	p.bcr.ResolveConflicts(p.bc, p.br)
+	return true
+}
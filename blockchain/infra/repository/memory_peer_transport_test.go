@@ -0,0 +1,277 @@
+package repository
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/blockchain/domain/repository"
+	"go-blockchain/utils"
+	wir "go-blockchain/wallet/infra/repository"
+)
+
+type testNode struct {
+	bc  *entity.Blockchain
+	bcr repository.BlockchainRepository
+	br  repository.BlockRepository
+}
+
+// newTestNode starts a proof-of-work node on mt that mines to a fresh
+// wallet of its own.
+func newTestNode(t *testing.T, mt *MemoryPeerTransport, port uint16) *testNode {
+	t.Helper()
+	return newClockedTestNode(t, mt, port, NewSystemClock())
+}
+
+// newClockedTestNode is newTestNode reading the time from clock.
+func newClockedTestNode(t *testing.T, mt *MemoryPeerTransport, port uint16, clock repository.Clock) *testNode {
+	t.Helper()
+	br := NewBlockRepository()
+	bcr := NewBlockchainRepository(mt, NewProofOfWork(), clock)
+	wr := wir.NewWalletRepository()
+	w := wir.NewWallet()
+	bc := NewBlockchain(br, bcr, wr.BlockchainAddress(w), port, MINING_BITS)
+	bc.RewardKey = wr.PrivateKey(w)
+	bc.MaxFutureDrift = MAX_FUTURE_DRIFT_SEC * time.Second
+	mt.Register(bc.Address, bc, bcr, br)
+	return &testNode{bc: bc, bcr: bcr, br: br}
+}
+
+// connect makes a and b neighbors of each other.
+func connect(t *testing.T, mt *MemoryPeerTransport, a *testNode, b *testNode) {
+	t.Helper()
+	if _, ok := mt.Handshake(a.bc, b.bc.Address, a.bcr.LocalPeer(a.bc)); !ok {
+		t.Fatalf("handshake %s -> %s refused", a.bc.Address, b.bc.Address)
+	}
+	a.bc.Neighbors = append(a.bc.Neighbors, b.bc.Address)
+}
+
+func mine(t *testing.T, n *testNode, blocks int) {
+	t.Helper()
+	for i := 0; i < blocks; i++ {
+		if !n.bcr.Mining(n.bc, n.br) {
+			t.Fatalf("%s did not mine", n.bc.Address)
+		}
+	}
+}
+
+func tip(n *testNode) [32]byte {
+	return n.br.Hash(n.bcr.LastBlock(n.bc))
+}
+
+func signedTransaction(t *testing.T, key *ecdsa.PrivateKey, sender string, recipient string, value float32) *entity.Transaction {
+	t.Helper()
+	h := utils.TransactionSigningHash(sender, recipient, value)
+	r, s, err := ecdsa.Sign(rand.Reader, key, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSignedTransaction(sender, recipient, value, &key.PublicKey, &utils.Signature{R: r, S: s})
+}
+
+func poolIDs(n *testNode) map[[32]byte]bool {
+	ids := make(map[[32]byte]bool)
+	for _, t := range n.bcr.TransactionPool(n.bc) {
+		ids[t.ID()] = true
+	}
+	return ids
+}
+
+const testRecipient = "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"
+
+func TestMemoryTransportGossip(t *testing.T) {
+	mt := NewMemoryPeerTransport()
+	a := newTestNode(t, mt, 5000)
+	b := newTestNode(t, mt, 5001)
+	c := newTestNode(t, mt, 5002)
+	connect(t, mt, a, b)
+	connect(t, mt, b, c)
+
+	mine(t, a, 1)
+	if tip(b) != tip(a) {
+		t.Fatalf("b did not adopt the block a announced")
+	}
+	if !c.bcr.ResolveConflicts(c.bc, c.br) || tip(c) != tip(a) {
+		t.Fatalf("c did not sync a's chain through b")
+	}
+
+	if !a.bcr.Pay(a.bc, testRecipient, 0.5) {
+		t.Fatalf("a could not pay from its reward")
+	}
+	paid := a.bcr.TransactionPool(a.bc)[0]
+	for _, n := range []*testNode{a, b, c} {
+		if ids := poolIDs(n); len(ids) != 1 || !ids[paid.ID()] {
+			t.Fatalf("%s pool has %d transactions, want the payment only", n.bc.Address, len(ids))
+		}
+	}
+	if c.bcr.CreateTransaction(c.bc, paid.SenderBlockchainAddress, paid.RecipientBlockchainAddress,
+		paid.Value, paid.SenderPublicKey, paid.Signature) {
+		t.Fatalf("c accepted a copy of a pooled payment")
+	}
+
+	// A payment only b knows of has to survive a's block.
+	only := signedTransaction(t, a.bc.RewardKey, a.bc.BlockchainAddress, testRecipient, 0.25)
+	if !b.bcr.AddTransaction(b.bc, only.SenderBlockchainAddress, only.RecipientBlockchainAddress,
+		only.Value, only.SenderPublicKey, only.Signature) {
+		t.Fatalf("b refused a payment a can afford")
+	}
+	mine(t, a, 1)
+	if ids := poolIDs(b); len(ids) != 1 || !ids[only.ID()] {
+		t.Fatalf("b pool has %d transactions after a's block, want the one not in it", len(ids))
+	}
+	if got := b.bcr.CalculateTotalAmount(b.bc, testRecipient); got != 0.5 {
+		t.Fatalf("recipient has %v on b, want 0.5", got)
+	}
+}
+
+func TestMemoryTransportConsensus(t *testing.T) {
+	mt := NewMemoryPeerTransport()
+	a := newTestNode(t, mt, 5000)
+	b := newTestNode(t, mt, 5001)
+
+	mine(t, a, 3)
+	mine(t, b, 1)
+	connect(t, mt, b, a)
+
+	if a.bcr.ResolveConflicts(a.bc, a.br) {
+		t.Fatalf("a replaced its chain with a lighter one")
+	}
+	if !b.bcr.ResolveConflicts(b.bc, b.br) {
+		t.Fatalf("b kept its lighter chain")
+	}
+	if tip(b) != tip(a) || len(b.bc.Chain) != 4 {
+		t.Fatalf("b did not adopt a's chain")
+	}
+	// The block b mined itself was reorganized away with its reward.
+	if got := b.bcr.CalculateTotalAmount(b.bc, b.bc.BlockchainAddress); got != 0 {
+		t.Fatalf("b's reward address has %v after the reorg, want 0", got)
+	}
+	if got := b.bcr.CalculateTotalAmount(b.bc, a.bc.BlockchainAddress); got != 3 {
+		t.Fatalf("a's reward address has %v on b, want 3", got)
+	}
+}
+
+func TestMemoryTransportReorgPastFinality(t *testing.T) {
+	mt := NewMemoryPeerTransport()
+	a := newTestNode(t, mt, 5000)
+	b := newTestNode(t, mt, 5001)
+	b.bc.FinalityDepth = 1
+
+	mine(t, a, 4)
+	mine(t, b, 2)
+	before := tip(b)
+	connect(t, mt, b, a)
+
+	if b.bcr.ResolveConflicts(b.bc, b.br) || tip(b) != before {
+		t.Fatalf("b reorganized 2 blocks with a finality depth of 1")
+	}
+	if m := b.bcr.Metrics(b.bc); m.RefusedReorgs != 1 {
+		t.Fatalf("refused reorgs = %d, want 1", m.RefusedReorgs)
+	}
+
+	b.bc.FinalityDepth = 2
+	if !b.bcr.ResolveConflicts(b.bc, b.br) || tip(b) != tip(a) {
+		t.Fatalf("b refused a reorg within its finality depth")
+	}
+}
+
+func TestMemoryTransportResolveThenMine(t *testing.T) {
+	mt := NewMemoryPeerTransport()
+	a := newTestNode(t, mt, 5000)
+	b := newTestNode(t, mt, 5001)
+	c := newTestNode(t, mt, 5002)
+	connect(t, mt, a, b)
+	connect(t, mt, b, c)
+
+	mine(t, a, 1)
+	if !c.bcr.ResolveConflicts(c.bc, c.br) {
+		t.Fatalf("c did not sync a's first block")
+	}
+	if !a.bcr.Pay(a.bc, testRecipient, 0.25) {
+		t.Fatalf("a could not pay from its reward")
+	}
+	if len(c.bcr.TransactionPool(c.bc)) != 1 {
+		t.Fatalf("the payment did not reach c")
+	}
+
+	// a confirms the payment; c only learns of it by syncing through b.
+	mine(t, a, 1)
+	if !c.bcr.ResolveConflicts(c.bc, c.br) || tip(c) != tip(a) {
+		t.Fatalf("c did not sync a's second block")
+	}
+	if n := len(c.bcr.TransactionPool(c.bc)); n != 0 {
+		t.Fatalf("c pool has %d transactions its new chain confirms", n)
+	}
+
+	mine(t, c, 1)
+	if n := len(c.bcr.LastBlock(c.bc).Transactions); n != 1 {
+		t.Fatalf("c mined %d transactions, want the coinbase only", n)
+	}
+	if !c.bcr.ValidChain(c.bc, c.br, c.bc.Chain) {
+		t.Fatalf("c mined an invalid chain")
+	}
+	if tip(b) != tip(c) {
+		t.Fatalf("b did not adopt c's block")
+	}
+	for _, n := range []*testNode{a, b, c} {
+		if got := n.bcr.CalculateTotalAmount(n.bc, testRecipient); got != 0.25 {
+			t.Fatalf("recipient has %v on %s, want 0.25", got, n.bc.Address)
+		}
+	}
+}
+
+func TestMemoryTransportReorgReturnsTransactions(t *testing.T) {
+	mt := NewMemoryPeerTransport()
+	a := newTestNode(t, mt, 5000)
+	b := newTestNode(t, mt, 5001)
+
+	mine(t, b, 1)
+	if !b.bcr.Pay(b.bc, testRecipient, 0.25) {
+		t.Fatalf("b could not pay from its reward")
+	}
+	paid := b.bcr.TransactionPool(b.bc)[0]
+	mine(t, b, 1)
+	if len(b.bcr.TransactionPool(b.bc)) != 0 {
+		t.Fatalf("b kept a payment it mined")
+	}
+
+	// The heavier chain of a does not have b's blocks, so the payment is
+	// owed again. It goes back into the pool even if it cannot be paid.
+	mine(t, a, 3)
+	connect(t, mt, b, a)
+	if !b.bcr.ResolveConflicts(b.bc, b.br) || tip(b) != tip(a) {
+		t.Fatalf("b did not adopt a's chain")
+	}
+	if ids := poolIDs(b); len(ids) != 1 || !ids[paid.ID()] {
+		t.Fatalf("b pool has %d transactions after the reorg, want the dropped payment", len(ids))
+	}
+}
+
+func TestMemoryTransportUnsealedBlockKeepsPool(t *testing.T) {
+	mt := NewMemoryPeerTransport()
+	a := newTestNode(t, mt, 5000)
+	b := newTestNode(t, mt, 5001)
+	connect(t, mt, a, b)
+	mine(t, a, 1)
+	if !a.bcr.Pay(a.bc, testRecipient, 0.25) {
+		t.Fatalf("a could not pay from its reward")
+	}
+
+	// A block with the payment and no proof of work.
+	forged := NewBlock(0, tip(b), append([]*entity.Transaction{
+		NewTransaction(MINING_SENDER, testRecipient, BlockReward(b.bc, len(b.bc.Chain))),
+	}, b.bcr.CopyTransactionPool(b.bc)...))
+	forged.Bits = b.bc.Bits
+	for validProof(forged) {
+		forged.Nonce += 1
+	}
+	if mt.AnnounceBlock(a.bc, b.bc.Address, forged); len(b.bcr.TransactionPool(b.bc)) != 1 {
+		t.Fatalf("an unsealed block emptied b's pool")
+	}
+	mine(t, a, 1)
+	if n := len(b.bcr.TransactionPool(b.bc)); n != 0 {
+		t.Fatalf("b pool has %d transactions after a's sealed block", n)
+	}
+}
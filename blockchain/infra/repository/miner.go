@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"log"
+	"time"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/blockchain/domain/repository"
+)
+
+const (
+	MINER_STATE_STOPPED  = "stopped"
+	MINER_STATE_IDLE     = "idle"
+	MINER_STATE_MINING   = "mining"
+	MINER_STATE_STOPPING = "stopping"
+)
+
+func NewMiner(interval time.Duration) *entity.Miner {
+	return &entity.Miner{Interval: interval}
+}
+
+// StartMining mines a block now and then every bc.Miner.Interval until
+// StopMining. It returns false when the miner was already running.
+func (bcr *blockchainRepository) StartMining(bc *entity.Blockchain, br repository.BlockRepository) bool {
+	m := bc.Miner
+	m.Mux.Lock()
+	defer m.Mux.Unlock()
+	if m.Running {
+		return false
+	}
+	m.Running = true
+	m.Generation += 1
+	m.NextRun = time.Now().UnixNano()
+	go bcr.runMiner(bc, br, m.Generation)
+	log.Printf("action=start_mining, interval=%v", m.Interval)
+	return true
+}
+
+// StopMining cancels the scheduled run and any proof of work in progress.
+// It returns false when the miner was not running.
+func (bcr *blockchainRepository) StopMining(bc *entity.Blockchain) bool {
+	m := bc.Miner
+	m.Mux.Lock()
+	if !m.Running {
+		m.Mux.Unlock()
+		return false
+	}
+	m.Running = false
+	m.NextRun = 0
+	if m.Timer != nil {
+		m.Timer.Stop()
+		m.Timer = nil
+	}
+	m.Mux.Unlock()
+
+	bc.Mux.Lock()
+	bcr.cancelMining(bc)
+	bc.Mux.Unlock()
+	log.Println("action=stop_mining")
+	return true
+}
+
+// runMiner mines once and schedules the next run. A run left over from
+// before a stop and restart sees a newer generation and does not
+// reschedule, so there is never more than one timer.
+func (bcr *blockchainRepository) runMiner(bc *entity.Blockchain, br repository.BlockRepository, generation int) {
+	m := bc.Miner
+	m.Mux.Lock()
+	if !m.Running || m.Generation != generation {
+		m.Mux.Unlock()
+		return
+	}
+	m.Busy = true
+	m.NextRun = 0
+	m.Mux.Unlock()
+
+	bcr.Mining(bc, br)
+
+	m.Mux.Lock()
+	defer m.Mux.Unlock()
+	m.Busy = false
+	if !m.Running || m.Generation != generation {
+		return
+	}
+	m.Timer = time.AfterFunc(m.Interval, func() { bcr.runMiner(bc, br, generation) })
+	m.NextRun = time.Now().Add(m.Interval).UnixNano()
+}
+
+func (bcr *blockchainRepository) MiningStatus(bc *entity.Blockchain) *entity.MiningStatus {
+	bc.MuxHashrate.Lock()
+	hashrate := bc.Hashrate
+	bc.MuxHashrate.Unlock()
+
+	m := bc.Miner
+	m.Mux.Lock()
+	defer m.Mux.Unlock()
+	state := MINER_STATE_STOPPED
+	switch {
+	case m.Running && m.Busy:
+		state = MINER_STATE_MINING
+	case m.Running:
+		state = MINER_STATE_IDLE
+	case m.Busy:
+		state = MINER_STATE_STOPPING
+	}
+	return &entity.MiningStatus{
+		State:                state,
+		Hashrate:             hashrate,
+		LastBlock:            m.LastBlock,
+		LastBlockHeight:      m.LastBlockHeight,
+		NextRun:              m.NextRun,
+		Interval:             m.Interval,
+		OnlyWithTransactions: m.OnlyWithTransactions,
+	}
+}
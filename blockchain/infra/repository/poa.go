@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/blockchain/domain/repository"
+	"go-blockchain/utils"
+)
+
+const CONSENSUS_POA = "poa"
+
+// proofOfAuthority lets a fixed list of validators take turns: the block at
+// height h must be signed by validators[(h-1) % len(validators)] with its
+// node identity. Blocks carry no proof of work, so bits and nonce are 0.
+type proofOfAuthority struct {
+	validators []*ecdsa.PublicKey
+}
+
+func NewProofOfAuthority(validators []*ecdsa.PublicKey) repository.ConsensusEngine {
+	return &proofOfAuthority{validators: validators}
+}
+
+// LoadValidators reads the validators' node IDs, one per line, in the order
+// they take turns. Blank lines and lines starting with # are skipped.
+func LoadValidators(path string) ([]*ecdsa.PublicKey, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	validators := make([]*ecdsa.PublicKey, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b, err := hex.DecodeString(line)
+		if err != nil || len(b) != 64 {
+			return nil, fmt.Errorf("invalid validator %q in %s", line, path)
+		}
+		x, y := new(big.Int).SetBytes(b[:32]), new(big.Int).SetBytes(b[32:])
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid validator %q in %s", line, path)
+		}
+		validators = append(validators, &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(validators) == 0 {
+		return nil, fmt.Errorf("no validators in %s", path)
+	}
+	return validators, nil
+}
+
+func (poa *proofOfAuthority) Name() string {
+	return CONSENSUS_POA
+}
+
+// Genesis commits to the validator list, so networks with different
+// validators refuse each other in the handshake.
+func (poa *proofOfAuthority) Genesis(bc *entity.Blockchain) *entity.Block {
+	h := sha256.New()
+	for _, v := range poa.validators {
+		h.Write([]byte(NodeID(v)))
+	}
+	var previousHash [32]byte
+	copy(previousHash[:], h.Sum(nil))
+	return NewGenesisBlock(previousHash, 0)
+}
+
+// validator is whose turn it is at height.
+func (poa *proofOfAuthority) validator(height int) *ecdsa.PublicKey {
+	return poa.validators[(height-1)%len(poa.validators)]
+}
+
+func (poa *proofOfAuthority) Prepare(bc *entity.Blockchain, parent *entity.Block, b *entity.Block) bool {
+	b.Bits = 0
+	b.Nonce = 0
+	if bc.Identity == nil || NodeID(poa.validator(b.Height)) != NodeID(&bc.Identity.PublicKey) {
+		return false
+	}
+	if b.Timestamp <= parent.Timestamp {
+		b.Timestamp = parent.Timestamp + 1
+	}
+	return true
+}
+
+// Seal signs the block hash with the node identity.
+func (poa *proofOfAuthority) Seal(ctx context.Context, bc *entity.Blockchain, b *entity.Block) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	h := b.Hash()
+	r, s, err := ecdsa.Sign(rand.Reader, bc.Identity, h[:])
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return false
+	}
+	b.Signature = &utils.Signature{R: r, S: s}
+	bc.MuxHashrate.Lock()
+	bc.Hashrate = 0
+	bc.MuxHashrate.Unlock()
+	return true
+}
+
+func (poa *proofOfAuthority) VerifySeal(bc *entity.Blockchain, parent *entity.Block, b *entity.Block) bool {
+	if b.Bits != 0 || b.Nonce != 0 || b.Signature == nil || b.Timestamp <= parent.Timestamp {
+		return false
+	}
+	h := b.Hash()
+	return ecdsa.Verify(poa.validator(b.Height), h[:], b.Signature.R, b.Signature.S)
+}
+
+// Weight is the chain length: every block is sealed in turn, so the longer
+// chain is the one more validators have built on.
+func (poa *proofOfAuthority) Weight(chain []*entity.Block) uint64 {
+	return uint64(len(chain))
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"log"
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/blockchain/domain/repository"
+	"go-blockchain/utils"
+)
+
+const (
+	CONSENSUS_POW = "pow"
+
+	// Workers check for cancellation and for a nonce found by another
+	// worker once every POW_CHECK_INTERVAL guesses.
+	POW_CHECK_INTERVAL = 1024
+)
+
+// proofOfWork seals a block with a nonce whose guess block hashes to at
+// most the target in bc.Bits. The chain with the most expected hashes wins.
+type proofOfWork struct{}
+
+func NewProofOfWork() repository.ConsensusEngine {
+	return &proofOfWork{}
+}
+
+func (pow *proofOfWork) Name() string {
+	return CONSENSUS_POW
+}
+
+// Genesis differs between networks with different bits, so their nodes
+// refuse each other in the handshake.
+func (pow *proofOfWork) Genesis(bc *entity.Blockchain) *entity.Block {
+	return NewGenesisBlock((&entity.Block{}).Hash(), bc.Bits)
+}
+
+func (pow *proofOfWork) Prepare(bc *entity.Blockchain, parent *entity.Block, b *entity.Block) bool {
+	b.Bits = bc.Bits
+	b.Nonce = 0
+	return true
+}
+
+// Seal searches a nonce for b on bc.MiningWorkers goroutines and records
+// the hashrate on bc. It gives up and returns false as soon as ctx is
+// cancelled.
+func (pow *proofOfWork) Seal(ctx context.Context, bc *entity.Blockchain, b *entity.Block) bool {
+	start := time.Now()
+	target, ok := utils.CompactToBig(b.Bits)
+	if !ok {
+		log.Printf("ERROR: invalid bits %08x", b.Bits)
+		return false
+	}
+	nonce, hashes, found := searchNonce(ctx, newPowHeader(b),
+		utils.TargetBytes(target), miningWorkers(bc))
+	bc.MuxHashrate.Lock()
+	bc.Hashrate = hashrate(hashes, time.Since(start))
+	bc.MuxHashrate.Unlock()
+	if found {
+		b.Nonce = nonce
+	}
+	return found
+}
+
+func (pow *proofOfWork) VerifySeal(bc *entity.Blockchain, parent *entity.Block, b *entity.Block) bool {
+	return b.Bits == bc.Bits && validProof(b)
+}
+
+// Weight is the expected number of hashes it took to build chain.
+func (pow *proofOfWork) Weight(chain []*entity.Block) uint64 {
+	work := new(big.Int)
+	for _, b := range chain {
+		if target, ok := utils.CompactToBig(b.Bits); ok {
+			work.Add(work, utils.TargetWork(target))
+		}
+	}
+	if !work.IsUint64() {
+		return math.MaxUint64
+	}
+	return work.Uint64()
+}
+
+// validProof reports whether the block hash is at most the target encoded
+// in its bits.
+func validProof(b *entity.Block) bool {
+	target, ok := utils.CompactToBig(b.Bits)
+	if !ok {
+		return false
+	}
+	return utils.HashMeetsTarget(b.Hash(), utils.TargetBytes(target))
+}
+
+// powHeader is the header of a block being sealed, serialized once and
+// split around the nonce so that a guess only writes the nonce itself.
+type powHeader struct {
+	prefix []byte
+	suffix []byte
+}
+
+// newPowHeader lays out b's header as Hash does, so a nonce found here is
+// accepted by ValidChain on every node.
+func newPowHeader(b *entity.Block) *powHeader {
+	header := utils.EncodeHeader(b.PreviousHash, entity.TransactionsHash(b.Transactions), b.Timestamp, b.Bits, 0)
+	return &powHeader{prefix: header[:utils.HEADER_SIZE-8], suffix: header[utils.HEADER_SIZE:]}
+}
+
+// valid hashes the header with nonce into buf, which each worker owns.
+func (h *powHeader) valid(buf []byte, nonce int, target [32]byte) ([]byte, bool) {
+	buf = append(buf[:0], h.prefix...)
+	buf = appendNonce(buf, nonce)
+	buf = append(buf, h.suffix...)
+	return buf, utils.HashMeetsTarget(sha256.Sum256(buf), target)
+}
+
+// hash is valid without the buffer reuse, for checking a single nonce.
+func (h *powHeader) hash(nonce int) [32]byte {
+	buf := make([]byte, 0, len(h.prefix)+len(h.suffix)+8)
+	buf = append(buf, h.prefix...)
+	buf = appendNonce(buf, nonce)
+	return sha256.Sum256(append(buf, h.suffix...))
+}
+
+func appendNonce(buf []byte, nonce int) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(nonce))
+	return append(buf, b[:]...)
+}
+
+// searchNonce splits the nonce space across workers: worker i tries i,
+// i+workers, i+2*workers and so on. It returns the lowest nonce seen valid
+// when the search stopped, along with the number of hashes computed.
+func searchNonce(ctx context.Context, h *powHeader, target [32]byte, workers int) (int, uint64, bool) {
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		hashes uint64
+		found  int32
+		mux    sync.Mutex
+		best   = -1
+		wg     sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(nonce int) {
+			defer wg.Done()
+			buf := make([]byte, 0, len(h.prefix)+len(h.suffix)+8)
+			var ok bool
+			for count := 1; ; count++ {
+				if buf, ok = h.valid(buf, nonce, target); ok {
+					atomic.AddUint64(&hashes, uint64(count))
+					mux.Lock()
+					if best < 0 || nonce < best {
+						best = nonce
+					}
+					mux.Unlock()
+					atomic.StoreInt32(&found, 1)
+					return
+				}
+				if count%POW_CHECK_INTERVAL == 0 {
+					if atomic.LoadInt32(&found) == 1 || ctx.Err() != nil {
+						atomic.AddUint64(&hashes, uint64(count))
+						return
+					}
+				}
+				nonce += workers
+			}
+		}(w)
+	}
+	wg.Wait()
+	return best, hashes, best >= 0
+}
+
+// miningWorkers is the number of nonce search goroutines for bc.
+func miningWorkers(bc *entity.Blockchain) int {
+	if bc.MiningWorkers > 0 {
+		return bc.MiningWorkers
+	}
+	return runtime.NumCPU()
+}
+
+// hashrate is in hashes per second.
+func hashrate(hashes uint64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(hashes) / elapsed.Seconds()
+}
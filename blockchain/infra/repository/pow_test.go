@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/utils"
+)
+
+const (
+	BENCH_TEMPLATES    = 20
+	BENCH_TRANSACTIONS = 50
+	// The original loop wanted LEGACY_DIFFICULTY leading hex zeros, about
+	// the work MINING_BITS asks for.
+	LEGACY_DIFFICULTY = 3
+)
+
+func benchTemplates() []*entity.Block {
+	templates := make([]*entity.Block, BENCH_TEMPLATES)
+	for i := range templates {
+		ts := make([]*entity.Transaction, BENCH_TRANSACTIONS)
+		for j := range ts {
+			ts[j] = NewTransaction(fmt.Sprintf("sender%d", j), fmt.Sprintf("recipient%d", j), float32(j))
+		}
+		templates[i] = NewBlock(0, sha256.Sum256([]byte(fmt.Sprint(i))), ts)
+		templates[i].Bits = MINING_BITS
+	}
+	return templates
+}
+
+// legacyBlock is a block the way the original loop hashed it: with the
+// default encoding/json layout and a zero timestamp.
+type legacyBlock struct {
+	Timestamp    int64
+	Nonce        int
+	PreviousHash [32]byte
+	Transactions []*legacyTransaction
+}
+
+type legacyTransaction struct {
+	SenderBlockchainAddress    string
+	RecipientBlockchainAddress string
+	Value                      float32
+}
+
+// legacyProofOfWork is the original nonce loop: marshal the whole block to
+// JSON for every guess and compare the hex of its hash with zeros.
+func legacyProofOfWork(template *entity.Block) int {
+	transactions := make([]*legacyTransaction, 0, len(template.Transactions))
+	for _, t := range template.Transactions {
+		transactions = append(transactions, &legacyTransaction{
+			SenderBlockchainAddress:    t.SenderBlockchainAddress,
+			RecipientBlockchainAddress: t.RecipientBlockchainAddress,
+			Value:                      t.Value,
+		})
+	}
+	zeros := strings.Repeat("0", LEGACY_DIFFICULTY)
+	for nonce := 0; ; nonce++ {
+		guess := legacyBlock{Nonce: nonce, PreviousHash: template.PreviousHash, Transactions: transactions}
+		m, _ := json.Marshal(guess)
+		if fmt.Sprintf("%x", sha256.Sum256(m))[:LEGACY_DIFFICULTY] == zeros {
+			return nonce
+		}
+	}
+}
+
+func BenchmarkLegacyProofOfWork(b *testing.B) {
+	templates := benchTemplates()
+	var hashes uint64
+	start := time.Now()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hashes += uint64(legacyProofOfWork(templates[i%len(templates)]) + 1)
+	}
+	b.ReportMetric(float64(hashes)/time.Since(start).Seconds(), "hashes/s")
+}
+
+func BenchmarkSearchNonce(b *testing.B) {
+	target, _ := utils.CompactToBig(MINING_BITS)
+	counts := []int{1}
+	if n := runtime.NumCPU(); n > 1 {
+		counts = append(counts, n)
+	}
+	for _, workers := range counts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			templates := benchTemplates()
+			headers := make([]*powHeader, len(templates))
+			for i, t := range templates {
+				headers[i] = newPowHeader(t)
+			}
+			var hashes uint64
+			start := time.Now()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, n, found := searchNonce(context.Background(), headers[i%len(headers)], utils.TargetBytes(target), workers)
+				if !found {
+					b.Fatal("no nonce found")
+				}
+				hashes += n
+			}
+			b.ReportMetric(float64(hashes)/time.Since(start).Seconds(), "hashes/s")
+		})
+	}
+}
+
+func TestSearchNonceFindsValidProof(t *testing.T) {
+	pow := NewProofOfWork()
+	for _, workers := range []int{1, 4} {
+		bc := &entity.Blockchain{Bits: MINING_BITS, MiningWorkers: workers}
+		for _, template := range benchTemplates()[:4] {
+			pow.Prepare(bc, nil, template)
+			if !pow.Seal(context.Background(), bc, template) || !pow.VerifySeal(bc, nil, template) {
+				t.Fatalf("workers=%d sealed an invalid nonce %d", workers, template.Nonce)
+			}
+		}
+	}
+}
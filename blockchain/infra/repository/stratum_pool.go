@@ -0,0 +1,424 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/blockchain/domain/repository"
+	"go-blockchain/blockchain/infra/stratum"
+	"go-blockchain/utils"
+)
+
+const (
+	POOL_SHARE_FACTOR      = 16
+	POOL_JOB_REFRESH_SEC   = 10
+	POOL_TIP_POLL_MSEC     = 500
+	POOL_IDLE_TIMEOUT_SEC  = 600
+	POOL_WRITE_TIMEOUT_SEC = 5
+
+	// Each subscription starts its nonces at a different multiple of
+	// POOL_NONCE_RANGE so workers on the same job do not repeat each other.
+	POOL_NONCE_RANGE = 1 << 32
+)
+
+// StratumPool lets outside workers mine for this node. Work is handed out
+// with a share target shareFactor times the block target, so every worker
+// regularly proves its effort. When a share also meets the block target
+// the block is submitted and the coinbase, which pays the node's address,
+// is split over the round's shares by regular transactions. Payouts only
+// spend the matured rewards of blocks the pool found that are still on the
+// chain, never other funds of the node's address. What cannot be paid yet
+// stays owed and is retried whenever the tip moves.
+type StratumPool struct {
+	shareFactor   int64
+	bc            *entity.Blockchain
+	bcr           repository.BlockchainRepository
+	br            repository.BlockRepository
+	mux           sync.Mutex
+	jobs          map[string]*poolJob
+	current       *poolJob
+	issued        time.Time
+	clients       map[*stratumClient]bool
+	subscriptions int
+	found         map[[32]byte]bool
+	muxPayout     sync.Mutex
+	payoutTip     [32]byte
+	paid          float32
+}
+
+type poolJob struct {
+	id           string
+	templateID   [32]byte
+	previousHash [32]byte
+	header       *powHeader
+	target       [32]byte
+	shareTarget  [32]byte
+	reward       float32
+	submitted    map[int]bool
+}
+
+type stratumClient struct {
+	mux        sync.Mutex
+	conn       net.Conn
+	w          *bufio.Writer
+	authorized map[string]bool
+}
+
+func NewStratumPool(shareFactor int64) *StratumPool {
+	return &StratumPool{
+		shareFactor: shareFactor,
+		jobs:        make(map[string]*poolJob),
+		clients:     make(map[*stratumClient]bool),
+		found:       make(map[[32]byte]bool),
+	}
+}
+
+func (sp *StratumPool) Listen(address string, bc *entity.Blockchain,
+	bcr repository.BlockchainRepository, br repository.BlockRepository) error {
+	sp.bc, sp.bcr, sp.br = bc, bcr, br
+	bc.Pool = &entity.Pool{Workers: make(map[string]*entity.PoolWorker)}
+
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	log.Printf("pool listening on %s, share target %dx the block target", address, sp.shareFactor)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				continue
+			}
+			go sp.serve(conn)
+		}
+	}()
+	go sp.watchTip()
+	return nil
+}
+
+// watchTip hands out a clean job as soon as the tip moves, and a refreshed
+// one every POOL_JOB_REFRESH_SEC so new transactions get mined.
+func (sp *StratumPool) watchTip() {
+	for range time.Tick(POOL_TIP_POLL_MSEC * time.Millisecond) {
+		tip := sp.br.Hash(sp.bcr.LastBlock(sp.bc))
+		sp.mux.Lock()
+		if tip != sp.payoutTip {
+			sp.payoutTip = tip
+			go sp.payOwed()
+		}
+		idle := len(sp.clients) == 0
+		clean := sp.current == nil || sp.current.previousHash != tip
+		due := time.Since(sp.issued) >= POOL_JOB_REFRESH_SEC*time.Second
+		sp.mux.Unlock()
+		if !idle && (clean || due) {
+			sp.newJob(clean)
+		}
+	}
+}
+
+func (sp *StratumPool) newJob(clean bool) {
+	tmpl := sp.bcr.MiningTemplate(sp.bc, sp.br, sp.bc.BlockchainAddress)
+	if tmpl == nil {
+		return
+	}
+	target, ok := utils.CompactToBig(tmpl.Block.Bits)
+	if !ok {
+		log.Printf("ERROR: invalid bits %08x", tmpl.Block.Bits)
+		return
+	}
+	job := &poolJob{
+		id:           hex.EncodeToString(tmpl.ID[:8]),
+		templateID:   tmpl.ID,
+		previousHash: tmpl.Block.PreviousHash,
+		header:       &powHeader{prefix: tmpl.HeaderPrefix, suffix: tmpl.HeaderSuffix},
+		target:       utils.TargetBytes(target),
+		shareTarget:  utils.TargetBytes(utils.ScaleTarget(target, sp.shareFactor)),
+		reward:       tmpl.Coinbase.Value,
+		submitted:    make(map[int]bool),
+	}
+
+	// Shares for the job this one replaces are still taken until the next
+	// job, older jobs and their submitted nonces are dropped.
+	sp.mux.Lock()
+	jobs := make(map[string]*poolJob)
+	if !clean && sp.current != nil {
+		jobs[sp.current.id] = sp.current
+	}
+	jobs[job.id] = job
+	sp.jobs = jobs
+	sp.current = job
+	sp.issued = time.Now()
+	clients := make([]*stratumClient, 0, len(sp.clients))
+	for c := range sp.clients {
+		clients = append(clients, c)
+	}
+	sp.mux.Unlock()
+
+	notify := stratum.NewNotify(sp.notifyJob(job, clean))
+	for _, c := range clients {
+		if err := c.send(notify); err != nil {
+			sp.drop(c)
+		}
+	}
+}
+
+func (sp *StratumPool) notifyJob(job *poolJob, clean bool) *stratum.Job {
+	return &stratum.Job{
+		ID:           job.id,
+		PreviousHash: hex.EncodeToString(job.previousHash[:]),
+		HeaderPrefix: hex.EncodeToString(job.header.prefix),
+		HeaderSuffix: hex.EncodeToString(job.header.suffix),
+		ShareTarget:  hex.EncodeToString(job.shareTarget[:]),
+		Clean:        clean,
+	}
+}
+
+func (sp *StratumPool) drop(c *stratumClient) {
+	sp.mux.Lock()
+	delete(sp.clients, c)
+	sp.mux.Unlock()
+	c.conn.Close()
+}
+
+func (c *stratumClient) send(v interface{}) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(POOL_WRITE_TIMEOUT_SEC * time.Second))
+	if err := stratum.WriteMessage(c.w, v); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (sp *StratumPool) serve(conn net.Conn) {
+	c := &stratumClient{conn: conn, w: bufio.NewWriter(conn), authorized: make(map[string]bool)}
+	defer sp.drop(c)
+	r := bufio.NewReaderSize(conn, stratum.MAX_LINE_SIZE)
+	for {
+		conn.SetReadDeadline(time.Now().Add(POOL_IDLE_TIMEOUT_SEC * time.Second))
+		line, err := stratum.ReadLine(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("ERROR: pool connection %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		var req stratum.Request
+		if err := json.Unmarshal(line, &req); err != nil || req.ID == nil {
+			log.Printf("ERROR: malformed pool request from %s", conn.RemoteAddr())
+			return
+		}
+		result, rpcErr := sp.handle(c, &req)
+		if err := c.send(&stratum.Response{ID: req.ID, Result: result, Error: rpcErr}); err != nil {
+			return
+		}
+		if req.Method == stratum.MethodAuthorize && rpcErr == nil {
+			sp.mux.Lock()
+			job := sp.current
+			sp.mux.Unlock()
+			if job == nil {
+				sp.newJob(true)
+			} else if err := c.send(stratum.NewNotify(sp.notifyJob(job, true))); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (sp *StratumPool) handle(c *stratumClient, req *stratum.Request) (interface{}, interface{}) {
+	switch req.Method {
+	case stratum.MethodSubscribe:
+		sp.mux.Lock()
+		sp.subscriptions += 1
+		id := fmt.Sprintf("%08x", sp.subscriptions)
+		start := sp.subscriptions * POOL_NONCE_RANGE
+		sp.mux.Unlock()
+		return []interface{}{id, start}, nil
+	case stratum.MethodAuthorize:
+		var address string
+		if len(req.Params) < 1 || json.Unmarshal(req.Params[0], &address) != nil || address == "" {
+			return false, stratum.NewError(stratum.ErrUnauthorized, "blockchain address required")
+		}
+		c.authorized[address] = true
+		sp.mux.Lock()
+		sp.clients[c] = true
+		sp.mux.Unlock()
+		log.Printf("action=pool_authorize, worker=%s, remote=%s", address, c.conn.RemoteAddr())
+		return true, nil
+	case stratum.MethodSubmit:
+		return sp.submit(c, req.Params)
+	default:
+		return nil, stratum.NewError(stratum.ErrOther, "unknown method")
+	}
+}
+
+func (sp *StratumPool) submit(c *stratumClient, params []json.RawMessage) (interface{}, interface{}) {
+	var address, jobID string
+	var nonce int
+	if len(params) != 3 || json.Unmarshal(params[0], &address) != nil ||
+		json.Unmarshal(params[1], &jobID) != nil || json.Unmarshal(params[2], &nonce) != nil {
+		return false, stratum.NewError(stratum.ErrOther, "expected [address, job, nonce]")
+	}
+	if !c.authorized[address] {
+		return false, stratum.NewError(stratum.ErrUnauthorized, "unauthorized worker")
+	}
+
+	sp.mux.Lock()
+	job, ok := sp.jobs[jobID]
+	if !ok {
+		sp.mux.Unlock()
+		return false, stratum.NewError(stratum.ErrJobNotFound, "job not found")
+	}
+	if job.submitted[nonce] {
+		sp.mux.Unlock()
+		return false, stratum.NewError(stratum.ErrDuplicate, "duplicate share")
+	}
+	job.submitted[nonce] = true
+	sp.mux.Unlock()
+
+	hash := job.header.hash(nonce)
+	if !utils.HashMeetsTarget(hash, job.shareTarget) {
+		return false, stratum.NewError(stratum.ErrLowDifficulty, "low difficulty share")
+	}
+	sp.recordShare(address)
+
+	if utils.HashMeetsTarget(hash, job.target) && sp.bcr.SubmitBlock(sp.bc, sp.br, job.templateID, nonce) {
+		log.Printf("action=pool_block, worker=%s, hash=%x", address, hash)
+		sp.mux.Lock()
+		sp.found[hash] = true
+		sp.mux.Unlock()
+		go sp.payout(job.reward)
+		go sp.newJob(true)
+	}
+	return true, nil
+}
+
+func (sp *StratumPool) recordShare(address string) {
+	p := sp.bc.Pool
+	p.Mux.Lock()
+	defer p.Mux.Unlock()
+	w, ok := p.Workers[address]
+	if !ok {
+		w = &entity.PoolWorker{Address: address}
+		p.Workers[address] = w
+	}
+	w.Shares += 1
+	w.TotalShares += 1
+	w.LastShare = time.Now().Unix()
+}
+
+// payout ends the round: every worker is owed the share of the block
+// reward its shares make up of the round's shares.
+func (sp *StratumPool) payout(reward float32) {
+	p := sp.bc.Pool
+	p.Mux.Lock()
+	total := 0
+	for _, w := range p.Workers {
+		total += w.Shares
+	}
+	for address, w := range p.Workers {
+		if w.Shares > 0 {
+			value := reward * float32(w.Shares) / float32(total)
+			log.Printf("action=pool_round, worker=%s, shares=%d/%d, value=%v", address, w.Shares, total, value)
+			w.Owed += value
+			w.Shares = 0
+		}
+	}
+	p.Mux.Unlock()
+	sp.payOwed()
+}
+
+// earned is what the matured coinbases of the pool's blocks on the chain add
+// up to. Blocks lost to a reorg no longer count. Call it with bc.Mux held.
+func (sp *StratumPool) earned(found map[[32]byte]bool) float32 {
+	var earned float32
+	for height, b := range sp.bc.Chain {
+		if height+sp.bc.CoinbaseMaturity >= len(sp.bc.Chain) {
+			break
+		}
+		if found[b.Hash()] && len(b.Transactions) > 0 && b.Transactions[0].SenderBlockchainAddress == MINING_SENDER {
+			earned += b.Transactions[0].Value
+		}
+	}
+	return earned
+}
+
+// payOwed pays what the workers are owed as far as the pool's matured
+// rewards allow. The rest, and any failed payment, stays owed for the next
+// attempt.
+func (sp *StratumPool) payOwed() {
+	sp.muxPayout.Lock()
+	defer sp.muxPayout.Unlock()
+	p := sp.bc.Pool
+	p.Mux.Lock()
+	owed := make(map[string]float32)
+	for address, w := range p.Workers {
+		if w.Owed > 0 {
+			owed[address] = w.Owed
+		}
+	}
+	p.Mux.Unlock()
+
+	sp.mux.Lock()
+	found := make(map[[32]byte]bool, len(sp.found))
+	for h := range sp.found {
+		found[h] = true
+	}
+	sp.mux.Unlock()
+
+	sp.bc.Mux.Lock()
+	available := sp.earned(found) - sp.paid
+	if spendable := sp.bcr.SpendableAmount(sp.bc, sp.bc.BlockchainAddress); spendable < available {
+		available = spendable
+	}
+	sp.bc.Mux.Unlock()
+	for address, value := range owed {
+		if value > available {
+			value = available
+		}
+		if value <= 0 {
+			continue
+		}
+		if !sp.bcr.Pay(sp.bc, address, value) {
+			log.Printf("ERROR: pool payout of %v to %s failed, retrying later", value, address)
+			continue
+		}
+		available -= value
+		sp.paid += value
+		p.Mux.Lock()
+		p.Workers[address].Owed -= value
+		p.Workers[address].Paid += value
+		p.Mux.Unlock()
+		log.Printf("action=pool_payout, worker=%s, value=%v", address, value)
+	}
+}
+
+// PoolWorkers returns a copy of the pool's per-worker share counts, or
+// false when the node does not run a pool.
+func (bcr *blockchainRepository) PoolWorkers(bc *entity.Blockchain) ([]*entity.PoolWorker, bool) {
+	if bc.Pool == nil {
+		return nil, false
+	}
+	bc.Pool.Mux.Lock()
+	defer bc.Pool.Mux.Unlock()
+	workers := make([]*entity.PoolWorker, 0, len(bc.Pool.Workers))
+	for _, w := range bc.Pool.Workers {
+		c := *w
+		workers = append(workers, &c)
+	}
+	return workers, true
+}
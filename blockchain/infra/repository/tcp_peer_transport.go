@@ -0,0 +1,478 @@
+package repository
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/blockchain/domain/repository"
+	"go-blockchain/blockchain/infra/p2p"
+	"go-blockchain/utils"
+)
+
+const (
+	P2P_PORT_OFFSET   = 1000
+	PING_INTERVAL_SEC = 30
+)
+
+// TCPPeerTransport speaks the binary protocol in package p2p over one
+// persistent connection per neighbor. Listen has to be called before the
+// transport is used, since incoming messages are dispatched to the
+// blockchain given there.
+type TCPPeerTransport struct {
+	clientTLS *tls.Config
+	serverTLS *tls.Config
+	bc        *entity.Blockchain
+	bcr       repository.BlockchainRepository
+	br        repository.BlockRepository
+	mux       sync.Mutex
+	conns     map[string]*tcpConn
+}
+
+type tcpConn struct {
+	mux  sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// NewTCPPeerTransport uses plain TCP unless the TLS configs are given. A
+// server config with ClientCAs requires neighbors to present a certificate.
+func NewTCPPeerTransport(clientTLS *tls.Config, serverTLS *tls.Config) *TCPPeerTransport {
+	return &TCPPeerTransport{
+		clientTLS: clientTLS,
+		serverTLS: serverTLS,
+		conns:     make(map[string]*tcpConn),
+	}
+}
+
+func (tt *TCPPeerTransport) Listen(address string, bc *entity.Blockchain,
+	bcr repository.BlockchainRepository, br repository.BlockRepository) error {
+	tt.bc, tt.bcr, tt.br = bc, bcr, br
+
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if tt.serverTLS != nil {
+		config := tt.serverTLS.Clone()
+		if config.ClientCAs != nil {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		ln = tls.NewListener(ln, config)
+	}
+	log.Printf("p2p listening on %s", address)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				continue
+			}
+			go tt.serve(conn)
+		}
+	}()
+	go tt.keepAlive()
+	return nil
+}
+
+func (tt *TCPPeerTransport) dial(peer string) (*tcpConn, error) {
+	dialer := &net.Dialer{Timeout: time.Second * PEER_TIMEOUT_SEC}
+	var conn net.Conn
+	var err error
+	if tt.clientTLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", peer, tt.clientTLS)
+	} else {
+		conn, err = dialer.Dial("tcp", peer)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tcpConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+func (c *tcpConn) roundTrip(bc *entity.Blockchain, msgType uint8, body []byte) (*p2p.Frame, error) {
+	if bc.Identity == nil {
+		return nil, fmt.Errorf("p2p: node has no identity")
+	}
+	payload, err := p2p.SignPayload(bc.Identity, msgType, body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.conn.SetDeadline(time.Now().Add(time.Second * PEER_TIMEOUT_SEC))
+	if err := p2p.WriteFrame(c.w, &p2p.Frame{Type: msgType, Payload: payload}); err != nil {
+		return nil, err
+	}
+	return p2p.ReadFrame(c.r)
+}
+
+func (tt *TCPPeerTransport) drop(peer string, c *tcpConn) {
+	tt.mux.Lock()
+	if tt.conns[peer] == c {
+		delete(tt.conns, peer)
+	}
+	tt.mux.Unlock()
+	c.conn.Close()
+}
+
+// connect returns the open connection to peer, dialing and handshaking
+// first when there is none.
+func (tt *TCPPeerTransport) connect(bc *entity.Blockchain, peer string) (*tcpConn, bool) {
+	tt.mux.Lock()
+	c, ok := tt.conns[peer]
+	tt.mux.Unlock()
+	if ok {
+		return c, true
+	}
+	if tt.bcr == nil {
+		return nil, false
+	}
+	if _, ok := tt.Handshake(bc, peer, tt.bcr.LocalPeer(bc)); !ok {
+		return nil, false
+	}
+	tt.mux.Lock()
+	c, ok = tt.conns[peer]
+	tt.mux.Unlock()
+	return c, ok
+}
+
+func (tt *TCPPeerTransport) request(bc *entity.Blockchain, peer string, msgType uint8, body []byte) (*p2p.Frame, error) {
+	c, ok := tt.connect(bc, peer)
+	if !ok {
+		return nil, fmt.Errorf("p2p: no connection to %s", peer)
+	}
+	f, err := c.roundTrip(bc, msgType, body)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		tt.drop(peer, c)
+		return nil, err
+	}
+	return f, nil
+}
+
+func (tt *TCPPeerTransport) Handshake(bc *entity.Blockchain, peer string, local *entity.Peer) (*entity.Peer, bool) {
+	if bc.Identity == nil {
+		log.Println("ERROR: p2p: node has no identity")
+		return nil, false
+	}
+	c, err := tt.dial(peer)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, false
+	}
+	hs := handshakeFromPeer(local)
+	copy(hs.NodeID[:], p2pNodeID(bc))
+	f, err := c.roundTrip(bc, p2p.MsgHandshake, hs.Encode())
+	if err != nil || f.Type != p2p.MsgHandshake {
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+		c.conn.Close()
+		return nil, false
+	}
+	remote, err := p2p.DecodeHandshake(f.Payload)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		c.conn.Close()
+		return nil, false
+	}
+
+	tt.mux.Lock()
+	if old, ok := tt.conns[peer]; ok {
+		old.conn.Close()
+	}
+	tt.conns[peer] = c
+	tt.mux.Unlock()
+	return peerFromHandshake(remote), true
+}
+
+func (tt *TCPPeerTransport) AnnounceBlock(bc *entity.Blockchain, peer string, b *entity.Block) bool {
+	f, err := tt.request(bc, peer, p2p.MsgBlock, p2p.EncodeBlocks([]*entity.Block{b}))
+	return err == nil && f.Type == p2p.MsgAck
+}
+
+func (tt *TCPPeerTransport) AnnounceTransactions(bc *entity.Blockchain, peer string, hashes [][32]byte) ([][32]byte, error) {
+	entries := make([]*p2p.InvEntry, 0, len(hashes))
+	for _, h := range hashes {
+		entries = append(entries, &p2p.InvEntry{Type: p2p.InvTransaction, Hash: h})
+	}
+	f, err := tt.request(bc, peer, p2p.MsgInv, p2p.EncodeInv(entries))
+	if err != nil {
+		return nil, err
+	}
+	if f.Type != p2p.MsgInv {
+		return nil, fmt.Errorf("p2p: inventory refused by %s", peer)
+	}
+	wanted, err := p2p.DecodeInv(f.Payload)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, err
+	}
+	missing := make([][32]byte, 0, len(wanted))
+	for _, entry := range wanted {
+		if entry.Type == p2p.InvTransaction {
+			missing = append(missing, entry.Hash)
+		}
+	}
+	return missing, nil
+}
+
+func (tt *TCPPeerTransport) SendTransaction(bc *entity.Blockchain, peer string, t *entity.Transaction,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
+	st := *t
+	st.SenderPublicKey = senderPublicKey
+	st.Signature = s
+	f, err := tt.request(bc, peer, p2p.MsgTx, p2p.EncodeTransaction(&st))
+	return err == nil && f.Type == p2p.MsgAck
+}
+
+func (tt *TCPPeerTransport) RequestChain(bc *entity.Blockchain, peer string) ([]*entity.Block, bool) {
+	f, err := tt.request(bc, peer, p2p.MsgGetBlocks, p2p.EncodeGetBlocks(0))
+	if err != nil || f.Type != p2p.MsgBlock {
+		return nil, false
+	}
+	blocks, err := p2p.DecodeBlocks(f.Payload)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return nil, false
+	}
+	return blocks, true
+}
+
+// TriggerConsensus announces our tip as a block inventory. A peer that does
+// not know it syncs its chain.
+func (tt *TCPPeerTransport) TriggerConsensus(bc *entity.Blockchain, peer string) bool {
+	if tt.br == nil {
+		return false
+	}
+	tip := &p2p.InvEntry{Type: p2p.InvBlock, Hash: tt.br.Hash(bc.Chain[len(bc.Chain)-1])}
+	f, err := tt.request(bc, peer, p2p.MsgInv, p2p.EncodeInv([]*p2p.InvEntry{tip}))
+	return err == nil && f.Type == p2p.MsgInv
+}
+
+func (tt *TCPPeerTransport) keepAlive() {
+	for range time.Tick(time.Second * PING_INTERVAL_SEC) {
+		tt.mux.Lock()
+		conns := make(map[string]*tcpConn, len(tt.conns))
+		for peer, c := range tt.conns {
+			conns[peer] = c
+		}
+		tt.mux.Unlock()
+
+		for peer, c := range conns {
+			nonce := uint64(time.Now().UnixNano())
+			f, err := c.roundTrip(tt.bc, p2p.MsgPing, p2p.EncodeNonce(nonce))
+			if err == nil && f.Type == p2p.MsgPong {
+				if n, err := p2p.DecodeNonce(f.Payload); err == nil && n == nonce {
+					continue
+				}
+			}
+			log.Printf("ERROR: %s did not answer ping", peer)
+			tt.drop(peer, c)
+		}
+	}
+}
+
+// serve answers the requests of one neighbor. The first message has to be a
+// handshake; the node ID in it is what every later message must be signed by.
+// Until the handshake is verified the neighbor is only known by its host,
+// afterwards by its node ID. The address it announces is never trusted for
+// scoring or bans.
+func (tt *TCPPeerTransport) serve(conn net.Conn) {
+	defer conn.Close()
+	if tc, ok := conn.(*tls.Conn); ok {
+		tc.SetDeadline(time.Now().Add(time.Second * PEER_TIMEOUT_SEC))
+		if err := tc.Handshake(); err != nil {
+			log.Printf("ERROR: %v", err)
+			return
+		}
+	}
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	peer := host
+	var nodeID [64]byte
+	bound := false
+	for {
+		conn.SetReadDeadline(time.Now().Add(time.Second * PING_INTERVAL_SEC * 3))
+		limit := uint32(p2p.MAX_PAYLOAD_SIZE)
+		if !bound {
+			limit = p2p.MAX_HANDSHAKE_SIZE
+		}
+		f, err := p2p.ReadFrameLimit(r, limit)
+		if err != nil {
+			if err != io.EOF {
+				if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+					tt.bcr.Misbehaving(tt.bc, peer, MISBEHAVIOR_MALFORMED, "malformed frame")
+				}
+			}
+			return
+		}
+		if tt.bcr.IsBanned(tt.bc, host) || tt.bcr.IsBanned(tt.bc, peer) {
+			log.Printf("ERROR: request from banned peer %s", peer)
+			return
+		}
+
+		var resp *p2p.Frame
+		if !bound {
+			if f.Type != p2p.MsgHandshake || len(f.Payload) < p2p.SIGNATURE_OVERHEAD {
+				tt.bcr.Misbehaving(tt.bc, peer, MISBEHAVIOR_MALFORMED, "expected handshake")
+				return
+			}
+			hs, err := p2p.DecodeHandshake(f.Payload[p2p.SIGNATURE_OVERHEAD:])
+			if err != nil {
+				tt.bcr.Misbehaving(tt.bc, peer, MISBEHAVIOR_MALFORMED, "malformed handshake")
+				return
+			}
+			if _, ok := p2p.OpenPayload(hs.NodeID, f.Type, f.Payload, PEER_MESSAGE_MAX_AGE_SEC); !ok {
+				tt.bcr.Misbehaving(tt.bc, peer, MISBEHAVIOR_BAD_SIGNATURE, "bad message signature")
+				return
+			}
+			peer = hex.EncodeToString(hs.NodeID[:])
+			if tt.bcr.IsBanned(tt.bc, peer) {
+				return
+			}
+			local, accepted := tt.bcr.AcceptHandshake(tt.bc, peerFromHandshake(hs))
+			if !accepted {
+				p2p.WriteFrame(w, &p2p.Frame{Type: p2p.MsgReject})
+				return
+			}
+			reply := handshakeFromPeer(local)
+			copy(reply.NodeID[:], p2pNodeID(tt.bc))
+			resp = &p2p.Frame{Type: p2p.MsgHandshake, Payload: reply.Encode()}
+			nodeID = hs.NodeID
+			bound = true
+		} else {
+			body, ok := p2p.OpenPayload(nodeID, f.Type, f.Payload, PEER_MESSAGE_MAX_AGE_SEC)
+			if !ok {
+				tt.bcr.Misbehaving(tt.bc, peer, MISBEHAVIOR_BAD_SIGNATURE, "bad message signature")
+				return
+			}
+			resp, ok = tt.handle(peer, f.Type, body)
+			if !ok {
+				tt.bcr.Misbehaving(tt.bc, peer, MISBEHAVIOR_MALFORMED, "malformed message")
+				return
+			}
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(time.Second * PEER_TIMEOUT_SEC))
+		if err := p2p.WriteFrame(w, resp); err != nil {
+			log.Printf("ERROR: %v", err)
+			return
+		}
+	}
+}
+
+func (tt *TCPPeerTransport) handle(peer string, msgType uint8, body []byte) (*p2p.Frame, bool) {
+	bc, bcr, br := tt.bc, tt.bcr, tt.br
+	switch msgType {
+	case p2p.MsgPing:
+		nonce, err := p2p.DecodeNonce(body)
+		if err != nil {
+			return nil, false
+		}
+		return &p2p.Frame{Type: p2p.MsgPong, Payload: p2p.EncodeNonce(nonce)}, true
+	case p2p.MsgInv:
+		entries, err := p2p.DecodeInv(body)
+		if err != nil {
+			return nil, false
+		}
+		hashes := make([][32]byte, 0, len(entries))
+		syncChain := false
+		for _, entry := range entries {
+			switch entry.Type {
+			case p2p.InvTransaction:
+				hashes = append(hashes, entry.Hash)
+			case p2p.InvBlock:
+				if entry.Hash != br.Hash(bcr.LastBlock(bc)) {
+					syncChain = true
+				}
+			}
+		}
+		missing := make([]*p2p.InvEntry, 0)
+		for _, h := range bcr.MissingTransactions(bc, hashes) {
+			missing = append(missing, &p2p.InvEntry{Type: p2p.InvTransaction, Hash: h})
+		}
+		if syncChain {
+			go bcr.ResolveConflicts(bc, br)
+		}
+		return &p2p.Frame{Type: p2p.MsgInv, Payload: p2p.EncodeInv(missing)}, true
+	case p2p.MsgGetBlocks:
+		from, err := p2p.DecodeGetBlocks(body)
+		if err != nil {
+			return nil, false
+		}
+		chain := bcr.Chain(bc)
+		if from > uint64(len(chain)) {
+			from = uint64(len(chain))
+		}
+		return &p2p.Frame{Type: p2p.MsgBlock, Payload: p2p.EncodeBlocks(chain[from:])}, true
+	case p2p.MsgBlock:
+		blocks, err := p2p.DecodeBlocks(body)
+		if err != nil {
+			return nil, false
+		}
+		for _, b := range blocks {
+			if !bcr.RemoveBlockTransactions(bc, b) {
+				log.Printf("block from %s does not seal onto our tip, pool kept", peer)
+			}
+		}
+		return &p2p.Frame{Type: p2p.MsgAck}, true
+	case p2p.MsgTx:
+		t, err := p2p.DecodeTransaction(body)
+		if err != nil || t.SenderPublicKey == nil {
+			return nil, false
+		}
+		if !bcr.ReceiveTransaction(bc, peer, t.SenderBlockchainAddress, t.RecipientBlockchainAddress,
+			t.Value, t.SenderPublicKey, t.Signature) {
+			return &p2p.Frame{Type: p2p.MsgReject}, true
+		}
+		return &p2p.Frame{Type: p2p.MsgAck}, true
+	}
+	return nil, false
+}
+
+func p2pNodeID(bc *entity.Blockchain) []byte {
+	b, _ := hex.DecodeString(NodeID(&bc.Identity.PublicKey))
+	return b
+}
+
+func handshakeFromPeer(p *entity.Peer) *p2p.Handshake {
+	return &p2p.Handshake{
+		ProtocolVersion: uint32(p.ProtocolVersion),
+		NetworkID:       p.NetworkID,
+		GenesisHash:     p.GenesisHash,
+		Height:          uint64(p.Height),
+		CumulativeWork:  p.CumulativeWork,
+		Address:         p.Address,
+	}
+}
+
+func peerFromHandshake(hs *p2p.Handshake) *entity.Peer {
+	return &entity.Peer{
+		ProtocolVersion: int(hs.ProtocolVersion),
+		NetworkID:       hs.NetworkID,
+		GenesisHash:     hs.GenesisHash,
+		Height:          int(hs.Height),
+		CumulativeWork:  hs.CumulativeWork,
+		Address:         hs.Address,
+		NodeID:          hex.EncodeToString(hs.NodeID[:]),
+	}
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"log"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/blockchain/domain/repository"
+)
+
+// Templates handed out to external miners are kept until the tip moves, up
+// to MAX_MINING_TEMPLATES at a time.
+const MAX_MINING_TEMPLATES = 64
+
+// newMiningTemplate builds a block on the current tip out of a coinbase
+// paying rewardAddress and the spendable part of the pool, or returns nil when the consensus engine
+// does not let this node seal on the tip. It must be called with bc.Mux
+// held.
+func (bcr *blockchainRepository) newMiningTemplate(bc *entity.Blockchain, br repository.BlockRepository, rewardAddress string) *entity.MiningTemplate {
+	coinbase := NewTransaction(MINING_SENDER, rewardAddress, BlockReward(bc, len(bc.Chain)))
+	transactions := []*entity.Transaction{coinbase}
+	// Leave out what the chain already confirms or no longer lets its
+	// sender spend.
+	l := chainLedger(bc, bc.Chain)
+	confirmed := make(map[[32]byte]bool)
+	for _, b := range bc.Chain {
+		for _, t := range b.Transactions {
+			confirmed[bcr.tr.Hash(t)] = true
+		}
+	}
+	for _, t := range bcr.CopyTransactionPool(bc) {
+		if id := bcr.tr.Hash(t); !confirmed[id] && l.spend(t) {
+			confirmed[id] = true
+			transactions = append(transactions, t)
+		}
+	}
+	parent := bcr.LastBlock(bc)
+	b := NewBlock(0, br.Hash(parent), transactions)
+	b.Timestamp = bcr.nextTimestamp(bc.Chain)
+	b.Height = len(bc.Chain)
+	if !bcr.ce.Prepare(bc, parent, b) {
+		return nil
+	}
+	h := newPowHeader(b)
+	return &entity.MiningTemplate{
+		ID:           sha256.Sum256(append(append([]byte{}, h.prefix...), h.suffix...)),
+		Height:       b.Height,
+		Block:        b,
+		Coinbase:     coinbase,
+		HeaderPrefix: h.prefix,
+		HeaderSuffix: h.suffix,
+	}
+}
+
+// MiningTemplate hands out work for an external miner and remembers it so
+// that SubmitBlock can find it by ID. It returns nil when this node may not
+// seal on the tip.
+func (bcr *blockchainRepository) MiningTemplate(bc *entity.Blockchain, br repository.BlockRepository, rewardAddress string) *entity.MiningTemplate {
+	bc.Mux.Lock()
+	defer bc.Mux.Unlock()
+	tmpl := bcr.newMiningTemplate(bc, br, rewardAddress)
+	if tmpl == nil {
+		return nil
+	}
+
+	if bc.MiningTemplates == nil {
+		bc.MiningTemplates = make(map[[32]byte]*entity.MiningTemplate)
+	}
+	for id, t := range bc.MiningTemplates {
+		if t.Block.PreviousHash != tmpl.Block.PreviousHash || len(bc.MiningTemplates) >= MAX_MINING_TEMPLATES {
+			delete(bc.MiningTemplates, id)
+		}
+	}
+	bc.MiningTemplates[tmpl.ID] = tmpl
+	return tmpl
+}
+
+// SubmitBlock completes the template id with nonce and appends it when the
+// proof is valid and the template still builds on the tip.
+func (bcr *blockchainRepository) SubmitBlock(bc *entity.Blockchain, br repository.BlockRepository, id [32]byte, nonce int) bool {
+	bc.Mux.Lock()
+	tmpl, ok := bc.MiningTemplates[id]
+	if !ok {
+		bc.Mux.Unlock()
+		log.Printf("ERROR: unknown mining template %x", id)
+		return false
+	}
+	parent := bcr.LastBlock(bc)
+	if tmpl.Block.PreviousHash != br.Hash(parent) {
+		bc.Mux.Unlock()
+		log.Printf("ERROR: stale mining template %x", id)
+		return false
+	}
+	b := NewBlock(nonce, tmpl.Block.PreviousHash, tmpl.Block.Transactions)
+	b.Timestamp = tmpl.Block.Timestamp
+	b.Bits = tmpl.Block.Bits
+	b.Height = tmpl.Height
+	if !bcr.ce.VerifySeal(bc, parent, b) {
+		bc.Mux.Unlock()
+		log.Printf("ERROR: invalid proof for mining template %x", id)
+		return false
+	}
+	bcr.appendBlock(bc, b)
+	bc.MiningTemplates = nil
+	bcr.cancelMining(bc)
+	bc.Mux.Unlock()
+	log.Printf("action=submit_block, status=success, template=%x", id)
+
+	bcr.announceBlock(bc, b)
+	return true
+}
+
+func (bcr *blockchainRepository) announceBlock(bc *entity.Blockchain, b *entity.Block) {
+	for _, n := range bc.Neighbors {
+		bcr.pt.AnnounceBlock(bc, n, b)
+		bcr.pt.TriggerConsensus(bc, n)
+	}
+}
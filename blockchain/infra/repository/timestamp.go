@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go-blockchain/blockchain/domain/entity"
+	"go-blockchain/blockchain/domain/repository"
+)
+
+const (
+	// A block's timestamp must exceed the median of the MEDIAN_TIME_SPAN
+	// blocks before it.
+	MEDIAN_TIME_SPAN = 11
+	// A block's timestamp may be at most MAX_FUTURE_DRIFT_SEC ahead of the
+	// local clock.
+	MAX_FUTURE_DRIFT_SEC = 120
+)
+
+type systemClock struct{}
+
+func NewSystemClock() repository.Clock {
+	return &systemClock{}
+}
+
+func (c *systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock only moves when told to.
+type ManualClock struct {
+	mux sync.Mutex
+	now time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) Set(now time.Time) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.now = now
+}
+
+func (c *ManualClock) Add(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// medianTimestamp is the median timestamp of the last MEDIAN_TIME_SPAN
+// blocks of chain.
+func medianTimestamp(chain []*entity.Block) int64 {
+	if len(chain) > MEDIAN_TIME_SPAN {
+		chain = chain[len(chain)-MEDIAN_TIME_SPAN:]
+	}
+	timestamps := make([]int64, 0, len(chain))
+	for _, b := range chain {
+		timestamps = append(timestamps, b.Timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+// nextTimestamp is the clock's time for a block on chain, moved past the
+// median if the clock is behind it.
+func (bcr *blockchainRepository) nextTimestamp(chain []*entity.Block) int64 {
+	timestamp := bcr.clock.Now().UnixNano()
+	if median := medianTimestamp(chain); timestamp <= median {
+		timestamp = median + 1
+	}
+	return timestamp
+}
+
+// validTimestamp checks chain[i] against the median of the blocks before it
+// and against bc.MaxFutureDrift.
+func (bcr *blockchainRepository) validTimestamp(bc *entity.Blockchain, chain []*entity.Block, i int) bool {
+	b := chain[i]
+	if median := medianTimestamp(chain[:i]); b.Timestamp <= median {
+		log.Printf("ERROR: block %d timestamp %d is not after the median %d", i, b.Timestamp, median)
+		return false
+	}
+	if limit := bcr.clock.Now().Add(bc.MaxFutureDrift).UnixNano(); b.Timestamp > limit {
+		log.Printf("ERROR: block %d timestamp %d is too far in the future", i, b.Timestamp)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go-blockchain/blockchain/domain/entity"
+)
+
+// newTimedNode mines MEDIAN_TIME_SPAN blocks a minute apart on clock.
+func newTimedNode(t *testing.T, clock *ManualClock) *testNode {
+	t.Helper()
+	n := newClockedTestNode(t, NewMemoryPeerTransport(), 5000, clock)
+	for i := 0; i < MEDIAN_TIME_SPAN; i++ {
+		clock.Add(time.Minute)
+		mine(t, n, 1)
+	}
+	return n
+}
+
+// withTipAt is n's chain with its tip sealed again at timestamp.
+func withTipAt(t *testing.T, n *testNode, timestamp int64) []*entity.Block {
+	t.Helper()
+	chain := append([]*entity.Block(nil), n.bc.Chain...)
+	tip := *chain[len(chain)-1]
+	tip.Timestamp = timestamp
+	pow := NewProofOfWork()
+	if !pow.Prepare(n.bc, chain[len(chain)-2], &tip) || !pow.Seal(context.Background(), n.bc, &tip) {
+		t.Fatalf("could not seal the tip at %d", timestamp)
+	}
+	chain[len(chain)-1] = &tip
+	return chain
+}
+
+func TestTimestampMedianBoundary(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	n := newTimedNode(t, clock)
+	median := medianTimestamp(n.bc.Chain[:len(n.bc.Chain)-1])
+
+	if n.bcr.ValidChain(n.bc, n.br, withTipAt(t, n, median)) {
+		t.Fatalf("accepted a tip at the median %d", median)
+	}
+	if !n.bcr.ValidChain(n.bc, n.br, withTipAt(t, n, median+1)) {
+		t.Fatalf("refused a tip just after the median %d", median)
+	}
+}
+
+func TestTimestampMinedBehindMedian(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	n := newTimedNode(t, clock)
+	median := medianTimestamp(n.bc.Chain)
+
+	// A clock set back before the median still mines a valid block.
+	clock.Set(time.Unix(0, median).Add(-time.Hour))
+	mine(t, n, 1)
+	if got := n.bcr.LastBlock(n.bc).Timestamp; got != median+1 {
+		t.Fatalf("mined at %d behind the median, want %d", got, median+1)
+	}
+}
+
+func TestTimestampFutureDriftBoundary(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	n := newTimedNode(t, clock)
+	limit := clock.Now().Add(n.bc.MaxFutureDrift).UnixNano()
+
+	if !n.bcr.ValidChain(n.bc, n.br, withTipAt(t, n, limit)) {
+		t.Fatalf("refused a tip exactly %v ahead", n.bc.MaxFutureDrift)
+	}
+	ahead := withTipAt(t, n, limit+1)
+	if n.bcr.ValidChain(n.bc, n.br, ahead) {
+		t.Fatalf("accepted a tip 1ns past %v ahead", n.bc.MaxFutureDrift)
+	}
+	clock.Add(time.Nanosecond)
+	if !n.bcr.ValidChain(n.bc, n.br, ahead) {
+		t.Fatalf("refused the tip once the clock caught up")
+	}
+}
@@ -1,12 +1,13 @@
 package repository
 
 import (
-	"encoding/json"
+	"crypto/ecdsa"
 	"fmt"
 	"strings"
 
 	"go-blockchain/blockchain/domain/entity"
 	"go-blockchain/blockchain/domain/repository"
+	"go-blockchain/utils"
 )
 
 type transactionRepository struct{}
@@ -19,6 +20,14 @@ func NewTransaction(sender string, recipient string, value float32) *entity.Tran
 	return &entity.Transaction{SenderBlockchainAddress: sender, RecipientBlockchainAddress: recipient, Value: value}
 }
 
+func NewSignedTransaction(sender string, recipient string, value float32,
+	senderPublicKey *ecdsa.PublicKey, s *utils.Signature) *entity.Transaction {
+	t := NewTransaction(sender, recipient, value)
+	t.SenderPublicKey = senderPublicKey
+	t.Signature = s
+	return t
+}
+
 func (tr *transactionRepository) Print(t *entity.Transaction) {
 	fmt.Printf("%s\n", strings.Repeat("-", 40))
 	fmt.Printf(" sender_blockchain_address      %s\n", t.SenderBlockchainAddress)
@@ -26,30 +35,7 @@ func (tr *transactionRepository) Print(t *entity.Transaction) {
 	fmt.Printf(" value                          %.1f\n", t.Value)
 }
 
-func (tr *transactionRepository) MarshalJSON(t *entity.Transaction) ([]byte, error) {
-	return json.Marshal(struct {
-		Sender    string  `json:"sender_blockchain_address"`
-		Recipient string  `json:"recipient_blockchain_address"`
-		Value     float32 `json:"value"`
-	}{
-		Sender:    t.SenderBlockchainAddress,
-		Recipient: t.RecipientBlockchainAddress,
-		Value:     t.Value,
-	})
-}
-
-func (tr *transactionRepository) UnmarshalJSON(t *entity.Transaction, data []byte) error {
-	v := &struct {
-		Sender    *string  `json:"sender_blockchain_address"`
-		Recipient *string  `json:"recipient_blockchain_address"`
-		Value     *float32 `json:"value"`
-	}{
-		Sender:    &t.SenderBlockchainAddress,
-		Recipient: &t.RecipientBlockchainAddress,
-		Value:     &t.Value,
-	}
-	if err := json.Unmarshal(data, &v); err != nil {
-		return err
-	}
-	return nil
+// Hash identifies a transaction, see utils.TransactionID.
+func (tr *transactionRepository) Hash(t *entity.Transaction) [32]byte {
+	return t.ID()
 }
@@ -0,0 +1,125 @@
+package stratum
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// The pool speaks Stratum-style JSON-RPC: one JSON object per line in both
+// directions. Workers send requests with an id and get a response with the
+// same id. The pool pushes notifications with a null id.
+//
+//	-> {"id":1,"method":"mining.subscribe","params":[]}
+//	<- {"id":1,"result":["<subscription>",<nonce start>],"error":null}
+//	-> {"id":2,"method":"mining.authorize","params":["<blockchain address>",""]}
+//	<- {"id":2,"result":true,"error":null}
+//	<- {"id":null,"method":"mining.notify","params":["<job>","<previous hash>","<header prefix>","<header suffix>","<share target>",true]}
+//	-> {"id":3,"method":"mining.submit","params":["<blockchain address>","<job>",12345]}
+//	<- {"id":3,"result":true,"error":null}
+//
+// A worker counts its nonces up from the start it got on subscribing. A
+// nonce solves a job when sha256(prefix || nonce || suffix), with the nonce
+// as 8 bytes big endian, is at most the share target. Prefix, suffix and
+// target are hex encoded.
+
+const (
+	MethodSubscribe = "mining.subscribe"
+	MethodAuthorize = "mining.authorize"
+	MethodNotify    = "mining.notify"
+	MethodSubmit    = "mining.submit"
+)
+
+// Error codes follow the ones commonly used by Stratum pools.
+const (
+	ErrOther         = 20
+	ErrJobNotFound   = 21
+	ErrDuplicate     = 22
+	ErrLowDifficulty = 23
+	ErrUnauthorized  = 24
+)
+
+// MAX_LINE_SIZE bounds a single message so a client cannot make the pool
+// buffer without limit.
+const MAX_LINE_SIZE = 64 << 10
+
+type Request struct {
+	ID     *int64            `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type Response struct {
+	ID     *int64      `json:"id"`
+	Result interface{} `json:"result"`
+	Error  interface{} `json:"error"`
+}
+
+type Notification struct {
+	ID     *int64        `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// Job is the decoded form of mining.notify.
+type Job struct {
+	ID           string
+	PreviousHash string
+	HeaderPrefix string
+	HeaderSuffix string
+	ShareTarget  string
+	Clean        bool
+}
+
+// NewError builds the [code, message, null] triple used in responses.
+func NewError(code int, message string) []interface{} {
+	return []interface{}{code, message, nil}
+}
+
+func NewNotify(j *Job) *Notification {
+	return &Notification{
+		Method: MethodNotify,
+		Params: []interface{}{j.ID, j.PreviousHash, j.HeaderPrefix, j.HeaderSuffix, j.ShareTarget, j.Clean},
+	}
+}
+
+// ParseNotify reads a mining.notify line as sent by NewNotify.
+func ParseNotify(params []json.RawMessage) (*Job, bool) {
+	if len(params) != 6 {
+		return nil, false
+	}
+	j := &Job{}
+	for i, dst := range []*string{&j.ID, &j.PreviousHash, &j.HeaderPrefix, &j.HeaderSuffix, &j.ShareTarget} {
+		if err := json.Unmarshal(params[i], dst); err != nil {
+			return nil, false
+		}
+	}
+	if err := json.Unmarshal(params[5], &j.Clean); err != nil {
+		return nil, false
+	}
+	return j, true
+}
+
+// ReadLine returns the next message line, without the newline. r should
+// be sized with bufio.NewReaderSize(conn, MAX_LINE_SIZE); longer lines
+// are an error.
+func ReadLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, io.ErrShortBuffer
+	}
+	if err != nil {
+		return nil, err
+	}
+	return line[:len(line)-1], nil
+}
+
+// WriteMessage writes v as one line.
+func WriteMessage(w io.Writer, v interface{}) error {
+	m, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(m, '\n'))
+	return err
+}
@@ -1,10 +1,17 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
+	"fmt"
 	"log"
+	"runtime"
+	"strconv"
+	"time"
 
+	"go-blockchain/blockchain/domain/repository"
 	bir "go-blockchain/blockchain/infra/repository"
+	"go-blockchain/utils"
 	wir "go-blockchain/wallet/infra/repository"
 )
 
@@ -13,13 +20,125 @@ func init() {
 }
 
 func main() {
+	port := flag.Uint("port", 5000, "TCP Port Number for Blockchain Server")
+	networkID := flag.String("network", bir.DEFAULT_NETWORK_ID, "Network ID peers must share")
+	banFile := flag.String("bans", "", "Ban list file (default bans_<port>.json)")
+	identityFile := flag.String("identity", "", "Node identity key file, created if missing (default node_<port>.key)")
+	allowedPeersFile := flag.String("allow-peers", "", "File of node IDs allowed to connect, one per line")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate; enables HTTPS to clients and neighbors")
+	tlsKey := flag.String("tls-key", "", "TLS private key")
+	tlsCA := flag.String("tls-ca", "", "CA bundle; requires neighbors to present a certificate signed by it")
+	transport := flag.String("transport", "tcp", "Node-to-node transport: tcp or http")
+	miningBits := flag.String("mining-bits", fmt.Sprintf("%08x", bir.MINING_BITS), "Compact proof-of-work target in hex; all nodes of a network must agree")
+	mine := flag.Bool("mine", true, "Start mining when the node starts")
+	miningInterval := flag.Duration("mining-interval", bir.MINING_TIMER_SEC*time.Second, "Pause between mined blocks")
+	mineOnlyWithTransactions := flag.Bool("mine-only-with-transactions", false, "Skip a mining run while the transaction pool is empty")
+	poolAddress := flag.String("pool", "", "Listen address for the Stratum mining pool, e.g. :3333 (disabled when empty)")
+	poolShareFactor := flag.Int64("pool-share-factor", bir.POOL_SHARE_FACTOR, "How many times easier than a block a pool share is")
+	miningWorkers := flag.Int("mining-workers", runtime.NumCPU(), "Goroutines searching nonces in parallel")
+	consensus := flag.String("consensus", bir.CONSENSUS_POW, "Consensus engine: pow or poa")
+	halvingInterval := flag.Int("halving-interval", bir.HALVING_INTERVAL, "Blocks between halvings of the block reward (0 never halves); all nodes of a network must agree")
+	coinbaseMaturity := flag.Int("coinbase-maturity", bir.COINBASE_MATURITY, "Blocks to build on a reward before it can be spent; all nodes of a network must agree")
+	maxFutureDrift := flag.Duration("max-future-drift", bir.MAX_FUTURE_DRIFT_SEC*time.Second, "How far ahead of the local clock a block timestamp may be")
+	finalityDepth := flag.Int("finality-depth", bir.FINALITY_DEPTH, "Refuse reorgs dropping more blocks than this (0 disables)")
+	reorgAlertDepth := flag.Int("reorg-alert-depth", bir.REORG_ALERT_DEPTH, "Log an alert for reorgs dropping this many blocks (0 disables)")
+	checkpointsFile := flag.String("checkpoints", "", "File of \"<height> <block hash>\" checkpoints, one per line")
+	validatorsFile := flag.String("validators", "", "File of validator node IDs in turn order, one per line (poa only)")
+	flag.Parse()
+	if *banFile == "" {
+		*banFile = fmt.Sprintf("bans_%d.json", *port)
+	}
+	if *identityFile == "" {
+		*identityFile = fmt.Sprintf("node_%d.key", *port)
+	}
+
+	bs := bir.NewBlockchainServer(uint16(*port))
+	bs.NetworkID = *networkID
+	bs.BanFile = *banFile
+	bs.IdentityFile = *identityFile
+	bs.AllowedPeersFile = *allowedPeersFile
+	bs.TLSCertFile = *tlsCert
+	bs.TLSKeyFile = *tlsKey
+	bs.TLSCAFile = *tlsCA
+
+	bs.PeerTransport = *transport
+	bs.MiningWorkers = *miningWorkers
+	bits, err := strconv.ParseUint(*miningBits, 16, 32)
+	if err != nil {
+		log.Fatalf("ERROR: -mining-bits: %v", err)
+	}
+	if _, ok := utils.CompactToBig(uint32(bits)); !ok {
+		log.Fatalf("ERROR: -mining-bits %s is not a valid target", *miningBits)
+	}
+	bs.MiningBits = uint32(bits)
+	bs.MiningInterval = *miningInterval
+	bs.MineOnStart = *mine
+	bs.MiningOnlyWithTransactions = *mineOnlyWithTransactions
+	bs.HalvingInterval = *halvingInterval
+	bs.CoinbaseMaturity = *coinbaseMaturity
+	bs.MaxFutureDrift = *maxFutureDrift
+	bs.FinalityDepth = *finalityDepth
+	bs.ReorgAlertDepth = *reorgAlertDepth
+	bs.CheckpointsFile = *checkpointsFile
+
+	var clientTLS, serverTLS *tls.Config
+	if *tlsCert != "" {
+		var err error
+		if clientTLS, err = utils.ClientTLSConfig(*tlsCert, *tlsKey, *tlsCA); err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		if serverTLS, err = utils.ServerTLSConfig(*tlsCert, *tlsKey, *tlsCA); err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+	}
+
+	var ce repository.ConsensusEngine
+	switch *consensus {
+	case bir.CONSENSUS_POW:
+		ce = bir.NewProofOfWork()
+	case bir.CONSENSUS_POA:
+		if *validatorsFile == "" {
+			log.Fatal("ERROR: -consensus poa needs -validators")
+		}
+		if *poolAddress != "" {
+			log.Fatal("ERROR: -pool needs -consensus pow")
+		}
+		validators, err := bir.LoadValidators(*validatorsFile)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		ce = bir.NewProofOfAuthority(validators)
+	default:
+		log.Fatalf("ERROR: unknown consensus %q", *consensus)
+	}
+
 	bsr := bir.NewBlockchainServerRepository()
-	bcr := bir.NewBlockchainRepository()
 	br := bir.NewBlockRepository()
 	wr := wir.NewWalletRepository()
 
-	port := flag.Uint("port", 5000, "TCP Port Number for Blockchain Server")
-	flag.Parse()
-	bs := bir.NewBlockchainServer(uint16(*port))
+	var bcr repository.BlockchainRepository
+	var tt *bir.TCPPeerTransport
+	switch *transport {
+	case "http":
+		bcr = bir.NewBlockchainRepository(bir.NewHTTPPeerTransport(clientTLS), ce, bir.NewSystemClock())
+	case "tcp":
+		bs.PeerPort = bs.Port + bir.P2P_PORT_OFFSET
+		tt = bir.NewTCPPeerTransport(clientTLS, serverTLS)
+		bcr = bir.NewBlockchainRepository(tt, ce, bir.NewSystemClock())
+	default:
+		log.Fatalf("ERROR: unknown transport %q", *transport)
+	}
+
+	bc := bsr.GetBlockchain(bs, bcr, br, wr)
+	if tt != nil {
+		if err := tt.Listen(fmt.Sprintf("0.0.0.0:%d", bs.PeerPort), bc, bcr, br); err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+	}
+	if *poolAddress != "" {
+		if err := bir.NewStratumPool(*poolShareFactor).Listen(*poolAddress, bc, bcr, br); err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+	}
 	bsr.Run(bs, bcr, br, wr)
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"go-blockchain/blockchain/infra/stratum"
+)
+
+// stratumworker is a minimal pool worker for trying out the node's
+// -pool mode. It mines every job it is sent on one goroutine and submits
+// each share it finds.
+//
+//	go run ./cmd/stratumworker -pool 127.0.0.1:3333 -address <blockchain address>
+
+func init() {
+	log.SetPrefix("stratumworker: ")
+}
+
+type message struct {
+	ID     *int64            `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  json.RawMessage   `json:"error"`
+}
+
+type worker struct {
+	address string
+	conn    net.Conn
+	mux     sync.Mutex
+	w       *bufio.Writer
+	nextID  int64
+	pending sync.Map
+	job     atomic.Value
+	start   int64
+	jobs    chan *stratum.Job
+}
+
+func main() {
+	pool := flag.String("pool", "127.0.0.1:3333", "Pool address")
+	address := flag.String("address", "", "Blockchain address the pool pays")
+	flag.Parse()
+	if *address == "" {
+		log.Fatal("ERROR: -address is required")
+	}
+
+	conn, err := net.Dial("tcp", *pool)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	wk := &worker{address: *address, conn: conn, w: bufio.NewWriter(conn), jobs: make(chan *stratum.Job, 1)}
+	go wk.mine()
+
+	wk.call(stratum.MethodSubscribe)
+	wk.call(stratum.MethodAuthorize, *address, "")
+
+	r := bufio.NewReaderSize(conn, stratum.MAX_LINE_SIZE)
+	for {
+		line, err := stratum.ReadLine(r)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		var m message
+		if err := json.Unmarshal(line, &m); err != nil {
+			log.Printf("ERROR: %v", err)
+			continue
+		}
+		if m.ID == nil {
+			if m.Method != stratum.MethodNotify {
+				continue
+			}
+			job, ok := stratum.ParseNotify(m.Params)
+			if !ok {
+				log.Println("ERROR: malformed job")
+				continue
+			}
+			log.Printf("job %s on %s, clean=%v", job.ID, job.PreviousHash[:16], job.Clean)
+			wk.job.Store(job)
+			select {
+			case wk.jobs <- job:
+			default:
+			}
+			continue
+		}
+		method, _ := wk.pending.LoadAndDelete(*m.ID)
+		if string(m.Error) != "null" && len(m.Error) > 0 {
+			log.Printf("%v rejected: %s", method, m.Error)
+			continue
+		}
+		switch method {
+		case stratum.MethodSubscribe:
+			var result []json.RawMessage
+			var start int64
+			if json.Unmarshal(m.Result, &result) == nil && len(result) == 2 && json.Unmarshal(result[1], &start) == nil {
+				atomic.StoreInt64(&wk.start, start)
+			}
+		case stratum.MethodSubmit:
+			log.Println("share accepted")
+		}
+	}
+}
+
+func (wk *worker) call(method string, params ...interface{}) {
+	wk.mux.Lock()
+	defer wk.mux.Unlock()
+	wk.nextID += 1
+	id := wk.nextID
+	wk.pending.Store(id, method)
+	if params == nil {
+		params = []interface{}{}
+	}
+	req := struct {
+		ID     int64         `json:"id"`
+		Method string        `json:"method"`
+		Params []interface{} `json:"params"`
+	}{id, method, params}
+	if err := stratum.WriteMessage(wk.w, req); err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	if err := wk.w.Flush(); err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+}
+
+// mine works on the latest job, starting over whenever a new one arrives.
+func (wk *worker) mine() {
+	job := <-wk.jobs
+	for {
+		prefix, err1 := hex.DecodeString(job.HeaderPrefix)
+		suffix, err2 := hex.DecodeString(job.HeaderSuffix)
+		if err1 != nil || err2 != nil {
+			log.Println("ERROR: malformed header")
+			job = <-wk.jobs
+			continue
+		}
+		buf := make([]byte, 0, len(prefix)+len(suffix)+8)
+		var n [8]byte
+		start := int(atomic.LoadInt64(&wk.start))
+		for nonce := start; ; nonce++ {
+			if (nonce-start)%256 == 0 && wk.job.Load().(*stratum.Job) != job {
+				job = wk.job.Load().(*stratum.Job)
+				break
+			}
+			buf = append(buf[:0], prefix...)
+			binary.BigEndian.PutUint64(n[:], uint64(nonce))
+			buf = append(buf, n[:]...)
+			buf = append(buf, suffix...)
+			h := sha256.Sum256(buf)
+			if hex.EncodeToString(h[:]) <= job.ShareTarget {
+				wk.call(stratum.MethodSubmit, wk.address, job.ID, nonce)
+			}
+		}
+	}
+}
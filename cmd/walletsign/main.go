@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"go-blockchain/wallet/infra/repository"
+)
+
+// walletsign signs a transaction exported by the wallet server's
+// /transaction/unsigned endpoint with a keystore wallet, so the key never
+// has to be on a machine that is online. The signed transaction is printed
+// for /transaction/broadcast.
+//
+//	go run ./cmd/walletsign -wallet-dir wallets -id <wallet id> -passphrase-file pass.txt < unsigned.txt > signed.txt
+//
+// Without -passphrase-file the passphrase is read from $WALLET_PASSPHRASE.
+
+func init() {
+	log.SetPrefix("walletsign: ")
+}
+
+func main() {
+	walletDir := flag.String("wallet-dir", "wallets", "Directory of encrypted wallet files")
+	id := flag.String("id", "", "ID of the wallet to sign with")
+	in := flag.String("in", "-", "Unsigned transaction, - for standard input")
+	passphraseFile := flag.String("passphrase-file", "", "File whose first line is the wallet passphrase")
+	flag.Parse()
+	if *id == "" {
+		log.Fatal("ERROR: -id is required")
+	}
+
+	passphrase := os.Getenv("WALLET_PASSPHRASE")
+	if *passphraseFile != "" {
+		b, err := ioutil.ReadFile(*passphraseFile)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		passphrase = strings.TrimRight(strings.SplitN(string(b), "\n", 2)[0], "\r")
+	}
+	if passphrase == "" {
+		log.Fatal("ERROR: no passphrase, use -passphrase-file or $WALLET_PASSPHRASE")
+	}
+
+	var data []byte
+	var err error
+	if *in == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(*in)
+	}
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	ot, err := repository.DecodeOfflineTransaction(string(data))
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	if ot.Signature != nil {
+		log.Fatal("ERROR: transaction is already signed")
+	}
+
+	ks, err := repository.NewKeystore(*walletDir)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	kr := repository.NewKeystoreRepository()
+	_, token, err := kr.Unlock(ks, *id, passphrase, time.Minute)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	t, signature, err := kr.Sign(ks, repository.NewTransactionRepository(), *id, token,
+		ot.SenderBlockchainAddress, ot.Path, ot.RecipientBlockchainAddress, ot.Value)
+	kr.Lock(ks, *id)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	ot.SenderPublicKey = t.SenderPublicKey
+	ot.Signature = signature
+
+	log.Printf("signed %v from %s to %s", ot.Value, ot.SenderBlockchainAddress, ot.RecipientBlockchainAddress)
+	os.Stdout.WriteString(repository.EncodeOfflineTransaction(ot) + "\n")
+}
@@ -4,5 +4,6 @@ go 1.17
 
 require (
 	github.com/btcsuite/btcutil v1.0.2
+	github.com/tyler-smith/go-bip39 v1.1.0
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
 )
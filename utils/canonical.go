@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Canonical encoding of transactions and block headers. Everything that is
+// hashed or signed goes through these functions, so the bytes only depend
+// on the values and not on how they happen to be represented in Go or in
+// JSON. See docs/encoding.md for the layout and test vectors.
+
+// HEADER_SIZE is the length of an encoded block header. The nonce is its
+// last 8 bytes.
+const HEADER_SIZE = 32 + 32 + 8 + 4 + 8
+
+func appendUint32(buf []byte, v uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return append(buf, b[:]...)
+}
+
+func appendVarString(buf []byte, s string) []byte {
+	var n [binary.MaxVarintLen64]byte
+	buf = append(buf, n[:binary.PutUvarint(n[:], uint64(len(s)))]...)
+	return append(buf, s...)
+}
+
+// EncodeTransaction is the part of a transaction its signature covers.
+func EncodeTransaction(sender string, recipient string, value float32) []byte {
+	buf := make([]byte, 0, len(sender)+len(recipient)+6)
+	buf = appendVarString(buf, sender)
+	buf = appendVarString(buf, recipient)
+	return appendUint32(buf, math.Float32bits(value))
+}
+
+// TransactionSigningHash is what the sender signs.
+func TransactionSigningHash(sender string, recipient string, value float32) [32]byte {
+	return sha256.Sum256(EncodeTransaction(sender, recipient, value))
+}
+
+// TransactionID identifies a transaction including its signature, so two
+// payments with the same fields are still told apart. s is nil for mining
+// rewards. (r, s) and (r, n-s) verify alike, so s is taken in its lower
+// form; otherwise anyone could give a copy of a payment a fresh id. r and s
+// must be in [1, n-1] like those of any valid signature.
+func TransactionID(sender string, recipient string, value float32, s *Signature) ([32]byte, error) {
+	buf := EncodeTransaction(sender, recipient, value)
+	if s != nil {
+		if !inScalarRange(s.R) || !inScalarRange(s.S) {
+			return [32]byte{}, fmt.Errorf("signature out of range")
+		}
+		var rs [64]byte
+		s.R.FillBytes(rs[:32])
+		lowS(s.S).FillBytes(rs[32:])
+		buf = append(buf, rs[:]...)
+	}
+	return sha256.Sum256(buf), nil
+}
+
+// inScalarRange reports whether v is in [1, n-1] on P-256.
+func inScalarRange(v *big.Int) bool {
+	return v != nil && v.Sign() > 0 && v.Cmp(elliptic.P256().Params().N) < 0
+}
+
+// lowS is the smaller of s and n-s on P-256.
+func lowS(s *big.Int) *big.Int {
+	n := elliptic.P256().Params().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) <= 0 {
+		return s
+	}
+	return new(big.Int).Sub(n, s)
+}
+
+// TransactionsHash commits a block header to its transactions, in order.
+func TransactionsHash(ids [][32]byte) [32]byte {
+	var n [binary.MaxVarintLen64]byte
+	buf := make([]byte, 0, binary.MaxVarintLen64+32*len(ids))
+	buf = append(buf, n[:binary.PutUvarint(n[:], uint64(len(ids)))]...)
+	for _, id := range ids {
+		buf = append(buf, id[:]...)
+	}
+	return sha256.Sum256(buf)
+}
+
+// EncodeHeader lays out a block header in HEADER_SIZE bytes.
+func EncodeHeader(previousHash [32]byte, transactionsHash [32]byte, timestamp int64, bits uint32, nonce uint64) []byte {
+	buf := make([]byte, 0, HEADER_SIZE)
+	buf = append(buf, previousHash[:]...)
+	buf = append(buf, transactionsHash[:]...)
+	buf = appendUint64(buf, uint64(timestamp))
+	buf = appendUint32(buf, bits)
+	return appendUint64(buf, nonce)
+}
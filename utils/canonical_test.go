@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// The vectors of docs/encoding.md.
+const (
+	vectorSender    = "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
+	vectorRecipient = "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"
+	vectorBits      = 0x1f0fffff
+	vectorTimestamp = 1700000000000000000
+	vectorNonce     = 12345
+)
+
+func TestCanonicalEncodingVectors(t *testing.T) {
+	signature := &Signature{R: big.NewInt(1), S: big.NewInt(2)}
+	highS := &Signature{R: big.NewInt(1), S: new(big.Int).Sub(elliptic.P256().Params().N, big.NewInt(2))}
+	signingHash := TransactionSigningHash(vectorSender, vectorRecipient, 1.5)
+	signedID, err := TransactionID(vectorSender, vectorRecipient, 1.5, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	highSID, err := TransactionID(vectorSender, vectorRecipient, 1.5, highS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coinbaseID, err := TransactionID("THE BLOCKCHAIN", vectorRecipient, 1.0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty := TransactionsHash(nil)
+	both := TransactionsHash([][32]byte{coinbaseID, signedID})
+	zero := sha256.Sum256(EncodeHeader([32]byte{}, empty, 0, 0, 0))
+	genesis := sha256.Sum256(EncodeHeader(zero, empty, 0, vectorBits, 0))
+	header := EncodeHeader(genesis, both, vectorTimestamp, vectorBits, vectorNonce)
+	headerHash := sha256.Sum256(header)
+
+	tests := []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"transaction", EncodeTransaction(vectorSender, vectorRecipient, 1.5),
+			"223141317a5031655035514765666932444d505466544c35534c6d7637446976664e6122314276424d53455973745765747154466e354175346d3447466737784a614e564e323fc00000"},
+		{"transaction signing hash", signingHash[:],
+			"35a66e50e6f667a79610f6aa5c29814ba20dad970d34846e3078513295987b3d"},
+		{"transaction id, r=1 s=2", signedID[:],
+			"1bbb63363b5e0f91fb0ebca8d66b2031f98266eed2695d009e0143d43c0774e4"},
+		{"transaction id, r=1 s=n-2", highSID[:],
+			"1bbb63363b5e0f91fb0ebca8d66b2031f98266eed2695d009e0143d43c0774e4"},
+		{"coinbase", EncodeTransaction("THE BLOCKCHAIN", vectorRecipient, 1.0),
+			"0e54484520424c4f434b434841494e22314276424d53455973745765747154466e354175346d3447466737784a614e564e323f800000"},
+		{"coinbase id", coinbaseID[:],
+			"973cbb1f32fb94a55bb2f78a4c3404ab5ed84836a5e8682a43d046b9e4bf6220"},
+		{"transactions hash, no transactions", empty[:],
+			"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d"},
+		{"transactions hash, coinbase then signed", both[:],
+			"d4053425a257ba521d1789b3aced425e4fa64f54d7e2b1e3d9a5d8eeb95557c1"},
+		{"hash of the all-zero block", zero[:],
+			"ac578be6a09d3a2fcc6b326b40d1a3793915625b475332cfd61c61ba80cf61f8"},
+		{"genesis hash, bits 1f0fffff", genesis[:],
+			"344fab4084f11f2d2ee5690f705f626557f4f8ad6917693134a2fb9295c6acd2"},
+		{"header on genesis, timestamp 1700000000000000000, nonce 12345", header,
+			"344fab4084f11f2d2ee5690f705f626557f4f8ad6917693134a2fb9295c6acd2d4053425a257ba521d1789b3aced425e4fa64f54d7e2b1e3d9a5d8eeb95557c117979cfe362a00001f0fffff0000000000003039"},
+		{"header hash", headerHash[:],
+			"5b6ce5214f7d27738e5ebf62b1d18aa51f0387b73e4e9ce69c0fda8e638d00fb"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(tt.got); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+	if len(header) != HEADER_SIZE {
+		t.Errorf("header is %d bytes, want %d", len(header), HEADER_SIZE)
+	}
+}
+
+func TestTransactionIDRejectsOutOfRangeSignature(t *testing.T) {
+	n := elliptic.P256().Params().N
+	huge := new(big.Int).Lsh(big.NewInt(1), 300)
+	tests := []struct {
+		name string
+		r, s *big.Int
+	}{
+		{"r zero", big.NewInt(0), big.NewInt(2)},
+		{"s zero", big.NewInt(1), big.NewInt(0)},
+		{"r negative", big.NewInt(-1), big.NewInt(2)},
+		{"s is n", big.NewInt(1), n},
+		{"s past n", big.NewInt(1), new(big.Int).Add(n, big.NewInt(2))},
+		{"r over 32 bytes", huge, big.NewInt(2)},
+		{"s over 32 bytes", big.NewInt(1), huge},
+		{"s missing", big.NewInt(1), nil},
+	}
+	for _, tt := range tests {
+		if _, err := TransactionID(vectorSender, vectorRecipient, 1.5, &Signature{R: tt.r, S: tt.s}); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestSignatureFromString(t *testing.T) {
+	s := &Signature{R: big.NewInt(1), S: new(big.Int).Sub(elliptic.P256().Params().N, big.NewInt(2))}
+	parsed, err := SignatureFromString(s.String())
+	if err != nil || parsed.R.Cmp(s.R) != 0 || parsed.S.Cmp(s.S) != 0 {
+		t.Fatalf("round trip gave %v, %v", parsed, err)
+	}
+	for _, bad := range []string{"", "00", s.String()[:127], s.String()[:126], s.String() + "00", "zz" + s.String()[2:]} {
+		if _, err := SignatureFromString(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
@@ -30,9 +30,16 @@ func String2BigIntTuple(s string) (big.Int, big.Int) {
 	return bix, biy
 }
 
-func SignatureFromString(s string) *Signature {
-	x, y := String2BigIntTuple(s)
-	return &Signature{&x, &y}
+// SignatureFromString parses the 64 bytes of hex written by String.
+func SignatureFromString(s string) (*Signature, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 64 {
+		return nil, fmt.Errorf("signature: want 64 bytes, got %d", len(b))
+	}
+	return &Signature{R: new(big.Int).SetBytes(b[:32]), S: new(big.Int).SetBytes(b[32:])}, nil
 }
 
 func PublicKeyFromString(s string) *ecdsa.PublicKey {
@@ -46,3 +53,25 @@ func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) *ecdsa.PrivateKe
 	_ = bi.SetBytes(b)
 	return &ecdsa.PrivateKey{*publicKey, &bi}
 }
+
+func HashesToStrings(hashes [][32]byte) []string {
+	s := make([]string, 0, len(hashes))
+	for _, h := range hashes {
+		s = append(s, fmt.Sprintf("%x", h))
+	}
+	return s
+}
+
+func HashesFromStrings(s []string) ([][32]byte, bool) {
+	hashes := make([][32]byte, 0, len(s))
+	for _, v := range s {
+		b, err := hex.DecodeString(v)
+		if err != nil || len(b) != 32 {
+			return nil, false
+		}
+		var h [32]byte
+		copy(h[:], b)
+		hashes = append(hashes, h)
+	}
+	return hashes, true
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"bytes"
+	"math/big"
+)
+
+// Targets are 256-bit numbers a block hash, read big endian, must not
+// exceed. In headers they are stored in the compact form used by Bitcoin:
+// the top byte of bits is a length in bytes and the low three bytes are
+// the most significant bytes of the target,
+//
+//	target = mantissa * 256^(exponent-3)
+//
+// 0x1f0fffff is 0x000fffff followed by 28 zero bytes, which is about the
+// same as asking for three leading zero hex digits.
+
+var maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// CompactToBig expands bits. It returns false for negative, zero or
+// overflowing targets.
+func CompactToBig(bits uint32) (*big.Int, bool) {
+	exponent := uint(bits >> 24)
+	mantissa := int64(bits & 0x007fffff)
+	if bits&0x00800000 != 0 || mantissa == 0 {
+		return nil, false
+	}
+	target := big.NewInt(mantissa)
+	if exponent <= 3 {
+		target.Rsh(target, 8*(3-exponent))
+	} else {
+		target.Lsh(target, 8*(exponent-3))
+	}
+	if target.Sign() == 0 || target.Cmp(maxTarget) > 0 {
+		return nil, false
+	}
+	return target, true
+}
+
+// BigToCompact is the inverse of CompactToBig, rounding the target down to
+// the precision the compact form has.
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() <= 0 {
+		return 0
+	}
+	size := uint32(len(target.Bytes()))
+	var mantissa uint32
+	if size <= 3 {
+		mantissa = uint32(target.Uint64() << (8 * (3 - size)))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, uint(8*(size-3))).Uint64())
+	}
+	// The mantissa is signed; keep its top bit clear.
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		size += 1
+	}
+	return size<<24 | mantissa
+}
+
+// TargetBytes is target as 32 big endian bytes, for comparing with hashes.
+func TargetBytes(target *big.Int) [32]byte {
+	var b [32]byte
+	target.FillBytes(b[:])
+	return b
+}
+
+// HashMeetsTarget reports whether hash, read as a big endian number, is at
+// most target.
+func HashMeetsTarget(hash [32]byte, target [32]byte) bool {
+	return bytes.Compare(hash[:], target[:]) <= 0
+}
+
+// TargetWork is the expected number of hashes needed to meet target,
+// 2^256 / (target+1).
+func TargetWork(target *big.Int) *big.Int {
+	d := new(big.Int).Add(target, big.NewInt(1))
+	return d.Div(new(big.Int).Lsh(big.NewInt(1), 256), d)
+}
+
+// ScaleTarget multiplies target by factor, capped at the largest target.
+func ScaleTarget(target *big.Int, factor int64) *big.Int {
+	t := new(big.Int).Mul(target, big.NewInt(factor))
+	if t.Cmp(maxTarget) > 0 {
+		return new(big.Int).Set(maxTarget)
+	}
+	return t
+}
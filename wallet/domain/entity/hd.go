@@ -0,0 +1,25 @@
+package entity
+
+import "crypto/ecdsa"
+
+// HDKey is an extended key of a hierarchical deterministic wallet.
+// PrivateKey is nil for keys derived from an extended public key.
+type HDKey struct {
+	PrivateKey *ecdsa.PrivateKey
+	PublicKey  *ecdsa.PublicKey
+	ChainCode  []byte
+	Depth      int
+	Index      uint32
+}
+
+// HDAddress is a receive (Chain 0) or change (Chain 1) address derived
+// from the account key of an HD wallet.
+type HDAddress struct {
+	Chain             uint32
+	Index             uint32
+	Path              string
+	BlockchainAddress string
+	PublicKey         string
+	Used              bool
+	Amount            float32
+}
@@ -0,0 +1,40 @@
+package entity
+
+import (
+	"sync"
+	"time"
+)
+
+// Keystore is a directory of encrypted wallet files and the wallets that
+// are currently unlocked, by ID.
+type Keystore struct {
+	Dir      string
+	Unlocked map[string]*UnlockedWallet
+	Mux      sync.Mutex
+}
+
+// UnlockedWallet holds the key of the wallet address and, for HD wallets,
+// the account key its other addresses are derived from. Token is the
+// session token Unlock handed out; signing requires it.
+type UnlockedWallet struct {
+	Wallet  *Wallet
+	Account *HDKey
+	Token   string
+	Until   time.Time
+	Timer   *time.Timer
+}
+
+// KeystoreWallet describes a wallet file without its private key. Type is
+// "hd" for HD wallets, whose Path is the account derivation path, "watch"
+// for watch-only wallets and empty for single keys. UnlockedUntil is 0
+// while the wallet is locked.
+type KeystoreWallet struct {
+	ID                string
+	Name              string
+	Type              string
+	Path              string
+	BlockchainAddress string
+	PublicKey         string
+	CreatedAt         int64
+	UnlockedUntil     int64
+}
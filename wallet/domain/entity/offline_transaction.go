@@ -0,0 +1,22 @@
+package entity
+
+import (
+	"crypto/ecdsa"
+
+	"go-blockchain/utils"
+)
+
+// OfflineTransaction is a payment handed from the online wallet server,
+// which builds it, to an offline signer and back. Path is the derivation
+// path of an HD sender's key, empty for other wallets. SenderPublicKey and
+// Signature stay nil until it is signed.
+type OfflineTransaction struct {
+	Version                    int
+	SenderBlockchainAddress    string
+	Path                       string
+	RecipientBlockchainAddress string
+	Value                      float32
+	SigningHash                [32]byte
+	SenderPublicKey            *ecdsa.PublicKey
+	Signature                  *utils.Signature
+}
@@ -1,6 +1,18 @@
 package entity
 
+import "net/http"
+
+// WalletServer listens on Host, an empty Host meaning every interface.
 type WalletServer struct {
-	Port    uint16
-	Gateway string
+	Host          string
+	Port          uint16
+	Gateway       string
+	TLSCertFile   string
+	TLSKeyFile    string
+	GatewayCAFile string
+	GatewayClient *http.Client
+	Keystore      *Keystore
+	// InsecureRawKeys serves POST /wallet and accepts sender_private_key,
+	// sending private keys over HTTP.
+	InsecureRawKeys bool
 }
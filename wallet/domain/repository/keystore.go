@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"time"
+
+	"go-blockchain/utils"
+	"go-blockchain/wallet/domain/entity"
+)
+
+type KeystoreRepository interface {
+	Create(ks *entity.Keystore, name string, passphrase string) (*entity.KeystoreWallet, error)
+	CreateHD(ks *entity.Keystore, name string, passphrase string, mnemonic string) (*entity.KeystoreWallet, string, error)
+	Watch(ks *entity.Keystore, name string, blockchainAddress string, publicKey string) (*entity.KeystoreWallet, error)
+	Get(ks *entity.Keystore, id string) (*entity.KeystoreWallet, error)
+	List(ks *entity.Keystore) ([]*entity.KeystoreWallet, error)
+	Unlock(ks *entity.Keystore, id string, passphrase string, timeout time.Duration) (*entity.KeystoreWallet, string, error)
+	Lock(ks *entity.Keystore, id string) bool
+	Addresses(ks *entity.Keystore, id string) ([]*entity.HDAddress, error)
+	NewAddress(ks *entity.Keystore, id string, chain uint32) (*entity.HDAddress, error)
+	Scan(ks *entity.Keystore, id string, used func(blockchainAddress string) (bool, error), gapLimit int) ([]*entity.HDAddress, error)
+	Sign(ks *entity.Keystore, tr TransactionRepository, id string, token string, sender string, path string, recipient string, value float32) (*entity.Transaction, *utils.Signature, error)
+}
@@ -9,8 +9,18 @@ type WalletServerRepository interface {
 	Port(ws *entity.WalletServer) uint16
 	Gateway(ws *entity.WalletServer) string
 	Index(ws *entity.WalletServer, w http.ResponseWriter, req *http.Request)
-	Wallet(wr WalletRepository, w http.ResponseWriter, req *http.Request)
-	CreateTransaction(ws *entity.WalletServer, tr TransactionRepository, w http.ResponseWriter, req *http.Request)
+	Wallet(ws *entity.WalletServer, wr WalletRepository, w http.ResponseWriter, req *http.Request)
+	CreateTransaction(ws *entity.WalletServer, tr TransactionRepository, kr KeystoreRepository, w http.ResponseWriter, req *http.Request)
+	UnsignedTransaction(ws *entity.WalletServer, kr KeystoreRepository, w http.ResponseWriter, req *http.Request)
+	BroadcastTransaction(ws *entity.WalletServer, w http.ResponseWriter, req *http.Request)
+	WalletHistory(ws *entity.WalletServer, w http.ResponseWriter, req *http.Request)
 	WalletAmount(ws *entity.WalletServer, w http.ResponseWriter, req *http.Request)
-	Run(ws *entity.WalletServer, wr WalletRepository, tr TransactionRepository)
+	Wallets(ws *entity.WalletServer, kr KeystoreRepository, w http.ResponseWriter, req *http.Request)
+	UnlockWallet(ws *entity.WalletServer, kr KeystoreRepository, w http.ResponseWriter, req *http.Request)
+	LockWallet(ws *entity.WalletServer, kr KeystoreRepository, w http.ResponseWriter, req *http.Request)
+	WatchWallet(ws *entity.WalletServer, kr KeystoreRepository, w http.ResponseWriter, req *http.Request)
+	WalletSummary(ws *entity.WalletServer, kr KeystoreRepository, w http.ResponseWriter, req *http.Request)
+	WalletAddresses(ws *entity.WalletServer, kr KeystoreRepository, w http.ResponseWriter, req *http.Request)
+	ScanWallet(ws *entity.WalletServer, kr KeystoreRepository, w http.ResponseWriter, req *http.Request)
+	Run(ws *entity.WalletServer, wr WalletRepository, tr TransactionRepository, kr KeystoreRepository)
 }
@@ -0,0 +1,83 @@
+package request
+
+// CreateWalletRequest creates an HD wallet when HD is true or a Mnemonic to
+// restore from is given, and a single key wallet otherwise.
+type CreateWalletRequest struct {
+	Name       *string `json:"name"`
+	Passphrase *string `json:"passphrase"`
+	HD         *bool   `json:"hd"`
+	Mnemonic   *string `json:"mnemonic"`
+}
+
+func (cr *CreateWalletRequest) Validate() bool {
+	if cr.Name == nil || cr.Passphrase == nil {
+		return false
+	}
+	return true
+}
+
+// UnlockWalletRequest unlocks for TimeoutSec seconds, or the server
+// default when it is omitted.
+type UnlockWalletRequest struct {
+	ID         *string `json:"id"`
+	Passphrase *string `json:"passphrase"`
+	TimeoutSec *int    `json:"timeout_sec"`
+}
+
+func (ur *UnlockWalletRequest) Validate() bool {
+	if ur.ID == nil || ur.Passphrase == nil {
+		return false
+	}
+	if ur.TimeoutSec != nil && *ur.TimeoutSec <= 0 {
+		return false
+	}
+	return true
+}
+
+type LockWalletRequest struct {
+	ID *string `json:"id"`
+}
+
+func (lr *LockWalletRequest) Validate() bool {
+	return lr.ID != nil
+}
+
+// NewAddressRequest derives a change address when Change is true and a
+// receive address otherwise.
+type NewAddressRequest struct {
+	ID     *string `json:"id"`
+	Change *bool   `json:"change"`
+}
+
+func (nr *NewAddressRequest) Validate() bool {
+	return nr.ID != nil
+}
+
+type ScanWalletRequest struct {
+	ID       *string `json:"id"`
+	GapLimit *int    `json:"gap_limit"`
+}
+
+func (sr *ScanWalletRequest) Validate() bool {
+	if sr.ID == nil {
+		return false
+	}
+	if sr.GapLimit != nil && *sr.GapLimit <= 0 {
+		return false
+	}
+	return true
+}
+
+// WatchWalletRequest needs a BlockchainAddress, a PublicKey, or both.
+type WatchWalletRequest struct {
+	Name              *string `json:"name"`
+	BlockchainAddress *string `json:"blockchain_address"`
+	PublicKey         *string `json:"public_key"`
+}
+
+func (wr *WatchWalletRequest) Validate() bool {
+	if wr.Name == nil || (wr.BlockchainAddress == nil && wr.PublicKey == nil) {
+		return false
+	}
+	return true
+}
@@ -1,6 +1,13 @@
 package request
 
+// TransactionRequest names either a keystore wallet by WalletID, which the
+// server signs with given the SessionToken its unlock returned, or the raw
+// sender key pair when the server runs with -insecure-raw-keys. With a
+// WalletID, SenderBlockchainAddress may pick which address of an HD wallet
+// pays.
 type TransactionRequest struct {
+	WalletID                   *string `json:"wallet_id"`
+	SessionToken               *string `json:"session_token"`
 	SenderPrivateKey           *string `json:"sender_private_key"`
 	SenderBlockchainAddress    *string `json:"sender_blockchain_address"`
 	RecipientBlockchainAddress *string `json:"recipient_blockchain_address"`
@@ -9,12 +16,50 @@ type TransactionRequest struct {
 }
 
 func (tr *TransactionRequest) Validate() bool {
+	if tr.RecipientBlockchainAddress == nil || tr.Value == nil {
+		return false
+	}
+	if tr.WalletID != nil {
+		return tr.SessionToken != nil && tr.SenderPrivateKey == nil
+	}
 	if tr.SenderPrivateKey == nil ||
 		tr.SenderBlockchainAddress == nil ||
-		tr.RecipientBlockchainAddress == nil ||
-		tr.SenderPublicKey == nil ||
-		tr.Value == nil {
+		tr.SenderPublicKey == nil {
 		return false
 	}
 	return true
 }
+
+// RawKey reports whether the request carries the sender's private key.
+func (tr *TransactionRequest) RawKey() bool {
+	return tr.SenderPrivateKey != nil
+}
+
+// UnsignedTransactionRequest builds a payment for an offline signer. The
+// sender is either given or is the address of keystore wallet WalletID,
+// typically a watch-only one.
+type UnsignedTransactionRequest struct {
+	WalletID                   *string `json:"wallet_id"`
+	SenderBlockchainAddress    *string `json:"sender_blockchain_address"`
+	RecipientBlockchainAddress *string `json:"recipient_blockchain_address"`
+	Value                      *string `json:"value"`
+}
+
+func (ur *UnsignedTransactionRequest) Validate() bool {
+	if (ur.SenderBlockchainAddress == nil && ur.WalletID == nil) ||
+		ur.RecipientBlockchainAddress == nil ||
+		ur.Value == nil {
+		return false
+	}
+	return true
+}
+
+// BroadcastTransactionRequest carries a transaction signed offline, in the
+// base64 or JSON exchange form.
+type BroadcastTransactionRequest struct {
+	Transaction *string `json:"transaction"`
+}
+
+func (br *BroadcastTransactionRequest) Validate() bool {
+	return br.Transaction != nil
+}
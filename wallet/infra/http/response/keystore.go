@@ -0,0 +1,39 @@
+package response
+
+type KeystoreWalletResponse struct {
+	ID                string `json:"id"`
+	Name              string `json:"name"`
+	Type              string `json:"type,omitempty"`
+	Path              string `json:"path,omitempty"`
+	BlockchainAddress string `json:"blockchain_address"`
+	PublicKey         string `json:"public_key"`
+	CreatedAt         int64  `json:"created_at"`
+	Unlocked          bool   `json:"unlocked"`
+	UnlockedUntil     int64  `json:"unlocked_until,omitempty"`
+	// Mnemonic is only returned once, when a new HD wallet is created.
+	Mnemonic string `json:"mnemonic,omitempty"`
+	// SessionToken is only returned by an unlock.
+	SessionToken string `json:"session_token,omitempty"`
+}
+
+type KeystoreWalletsResponse struct {
+	Wallets []*KeystoreWalletResponse `json:"wallets"`
+	Length  int                       `json:"length"`
+}
+
+type HDAddressResponse struct {
+	Chain             uint32  `json:"chain"`
+	Index             uint32  `json:"index"`
+	Path              string  `json:"path"`
+	BlockchainAddress string  `json:"blockchain_address"`
+	PublicKey         string  `json:"public_key"`
+	Used              bool    `json:"used"`
+	Amount            float32 `json:"amount"`
+}
+
+// HDAddressesResponse has the total Amount of the addresses after a scan.
+type HDAddressesResponse struct {
+	Addresses []*HDAddressResponse `json:"addresses"`
+	Length    int                  `json:"length"`
+	Amount    float32              `json:"amount"`
+}
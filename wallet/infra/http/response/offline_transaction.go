@@ -0,0 +1,13 @@
+package response
+
+// OfflineTransactionResponse shows the payment in Transaction, the base64
+// exchange form, so it can be checked before it is signed or broadcast.
+type OfflineTransactionResponse struct {
+	Transaction                string  `json:"transaction"`
+	SenderBlockchainAddress    string  `json:"sender_blockchain_address"`
+	Path                       string  `json:"path,omitempty"`
+	RecipientBlockchainAddress string  `json:"recipient_blockchain_address"`
+	Value                      float32 `json:"value"`
+	SigningHash                string  `json:"signing_hash"`
+	Signed                     bool    `json:"signed"`
+}
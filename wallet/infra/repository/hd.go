@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"go-blockchain/wallet/domain/entity"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+const (
+	// HD wallets follow BIP44, m/44'/coin'/account'/chain/index, with the
+	// testnet coin type until the chain registers its own.
+	HD_ACCOUNT_PATH = "m/44'/1'/0'"
+	HD_RECEIVE      = 0
+	HD_CHANGE       = 1
+	// HD_GAP_LIMIT unused addresses in a row end a scan.
+	HD_GAP_LIMIT = 20
+	// HD_MNEMONIC_BITS of entropy make a 24 word mnemonic.
+	HD_MNEMONIC_BITS = 256
+
+	HD_HARDENED = 0x80000000
+)
+
+// SLIP-10 derives P-256 keys the way BIP32 derives secp256k1 keys, with
+// its own master key salt.
+var hdSeedKey = []byte("Nist256p1 seed")
+
+// NewMnemonic returns a fresh BIP39 mnemonic.
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(HD_MNEMONIC_BITS)
+	if err != nil {
+		return "", err
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// SeedFromMnemonic checks the mnemonic's checksum and stretches it into the
+// BIP39 seed. Extra whitespace between words is ignored.
+func SeedFromMnemonic(mnemonic string) ([]byte, error) {
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+	return bip39.NewSeedWithErrorChecking(mnemonic, "")
+}
+
+// privateKeyFromBytes is the P-256 key with scalar d.
+func privateKeyFromBytes(d []byte) *ecdsa.PrivateKey {
+	privateKey := new(ecdsa.PrivateKey)
+	privateKey.Curve = elliptic.P256()
+	privateKey.D = new(big.Int).SetBytes(d)
+	privateKey.X, privateKey.Y = privateKey.Curve.ScalarBaseMult(d)
+	return privateKey
+}
+
+// NewMasterKey is the SLIP-10 master key of seed.
+func NewMasterKey(seed []byte) *entity.HDKey {
+	n := elliptic.P256().Params().N
+	mac := hmac.New(sha512.New, hdSeedKey)
+	mac.Write(seed)
+	I := mac.Sum(nil)
+	for {
+		d := new(big.Int).SetBytes(I[:32])
+		if d.Sign() != 0 && d.Cmp(n) < 0 {
+			break
+		}
+		mac = hmac.New(sha512.New, hdSeedKey)
+		mac.Write(I)
+		I = mac.Sum(nil)
+	}
+	privateKey := privateKeyFromBytes(I[:32])
+	return &entity.HDKey{PrivateKey: privateKey, PublicKey: &privateKey.PublicKey, ChainCode: I[32:]}
+}
+
+// DeriveChild derives child index of k. Hardened children need the
+// private key; the others can also be derived from the public key alone.
+func DeriveChild(k *entity.HDKey, index uint32) (*entity.HDKey, error) {
+	curve := elliptic.P256()
+	n := curve.Params().N
+	var i [4]byte
+	binary.BigEndian.PutUint32(i[:], index)
+
+	data := make([]byte, 0, 37)
+	if index >= HD_HARDENED {
+		if k.PrivateKey == nil {
+			return nil, fmt.Errorf("hardened child %d of a public key", index-HD_HARDENED)
+		}
+		d := make([]byte, 32)
+		k.PrivateKey.D.FillBytes(d)
+		data = append(append(data, 0), d...)
+	} else {
+		data = append(data, elliptic.MarshalCompressed(curve, k.PublicKey.X, k.PublicKey.Y)...)
+	}
+	data = append(data, i[:]...)
+
+	for {
+		mac := hmac.New(sha512.New, k.ChainCode)
+		mac.Write(data)
+		I := mac.Sum(nil)
+		child := &entity.HDKey{ChainCode: I[32:], Depth: k.Depth + 1, Index: index}
+		il := new(big.Int).SetBytes(I[:32])
+		if il.Cmp(n) < 0 {
+			if k.PrivateKey != nil {
+				d := il.Add(il, k.PrivateKey.D)
+				d.Mod(d, n)
+				if d.Sign() != 0 {
+					child.PrivateKey = privateKeyFromBytes(d.Bytes())
+					child.PublicKey = &child.PrivateKey.PublicKey
+					return child, nil
+				}
+			} else {
+				x, y := curve.ScalarBaseMult(I[:32])
+				x, y = curve.Add(x, y, k.PublicKey.X, k.PublicKey.Y)
+				if x.Sign() != 0 || y.Sign() != 0 {
+					child.PublicKey = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+					return child, nil
+				}
+			}
+		}
+		// The key is invalid, which happens with probability below 2^-127;
+		// SLIP-10 retries with 0x01 || IR || index.
+		data = append(append(append(data[:0], 1), I[32:]...), i[:]...)
+	}
+}
+
+// ParsePath parses a derivation path such as m/44'/1'/0'/0/5.
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(path, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("invalid derivation path %q", path)
+	}
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+		index, err := strconv.ParseUint(part, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid derivation path %q", path)
+		}
+		if hardened {
+			index += HD_HARDENED
+		}
+		indexes = append(indexes, uint32(index))
+	}
+	return indexes, nil
+}
+
+// DerivePath derives the key at path below k.
+func DerivePath(k *entity.HDKey, path string) (*entity.HDKey, error) {
+	indexes, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		if k, err = DeriveChild(k, index); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// NewHDAddress derives the address at chain/index below the account key,
+// which may be public only.
+func NewHDAddress(account *entity.HDKey, accountPath string, chain uint32, index uint32) (*entity.HDAddress, error) {
+	c, err := DeriveChild(account, chain)
+	if err != nil {
+		return nil, err
+	}
+	k, err := DeriveChild(c, index)
+	if err != nil {
+		return nil, err
+	}
+	return &entity.HDAddress{
+		Chain:             chain,
+		Index:             index,
+		Path:              fmt.Sprintf("%s/%d/%d", accountPath, chain, index),
+		BlockchainAddress: BlockchainAddressFromPublicKey(k.PublicKey),
+		PublicKey:         fmt.Sprintf("%064x%064x", k.PublicKey.X, k.PublicKey.Y),
+	}, nil
+}
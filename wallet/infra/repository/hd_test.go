@@ -0,0 +1,237 @@
+package repository
+
+import (
+	"crypto/elliptic"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+// The nist256p1 vectors of SLIP-0010, including its seed and derivation
+// retry cases.
+func TestSLIP10Vectors(t *testing.T) {
+	vectors := []struct {
+		seed       string
+		path       string
+		chainCode  string
+		privateKey string
+		publicKey  string
+	}{
+		{"000102030405060708090a0b0c0d0e0f", "m",
+			"beeb672fe4621673f722f38529c07392fecaa61015c80c34f29ce8b41b3cb6ea",
+			"612091aaa12e22dd2abef664f8a01a82cae99ad7441b7ef8110424915c268bc2",
+			"0266874dc6ade47b3ecd096745ca09bcd29638dd52c2c12117b11ed3e458cfa9e8"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'",
+			"3460cea53e6a6bb5fb391eeef3237ffd8724bf0a40e94943c98b83825342ee11",
+			"6939694369114c67917a182c59ddb8cafc3004e63ca5d3b84403ba8613debc0c",
+			"0384610f5ecffe8fda089363a41f56a5c7ffc1d81b59a612d0d649b2d22355590c"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1",
+			"4187afff1aafa8445010097fb99d23aee9f599450c7bd140b6826ac22ba21d0c",
+			"284e9d38d07d21e4e281b645089a94f4cf5a5a81369acf151a1c3a57f18b2129",
+			"03526c63f8d0b4bbbf9c80df553fe66742df4676b241dabefdef67733e070f6844"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'",
+			"98c7514f562e64e74170cc3cf304ee1ce54d6b6da4f880f313e8204c2a185318",
+			"694596e8a54f252c960eb771a3c41e7e32496d03b954aeb90f61635b8e092aa7",
+			"0359cf160040778a4b14c5f4d7b76e327ccc8c4a6086dd9451b7482b5a4972dda0"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'/2",
+			"ba96f776a5c3907d7fd48bde5620ee374d4acfd540378476019eab70790c63a0",
+			"5996c37fd3dd2679039b23ed6f70b506c6b56b3cb5e424681fb0fa64caf82aaa",
+			"029f871f4cb9e1c97f9f4de9ccd0d4a2f2a171110c61178f84430062230833ff20"},
+		{"000102030405060708090a0b0c0d0e0f", "m/0'/1/2'/2/1000000000",
+			"b9b7b82d326bb9cb5b5b121066feea4eb93d5241103c9e7a18aad40f1dde8059",
+			"21c4f269ef0a5fd1badf47eeacebeeaa3de22eb8e5b0adcd0f27dd99d34d0119",
+			"02216cd26d31147f72427a453c443ed2cde8a1e53c9cc44e5ddf739725413fe3f4"},
+		{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m",
+			"96cd4465a9644e31528eda3592aa35eb39a9527769ce1855beafc1b81055e75d",
+			"eaa31c2e46ca2962227cf21d73a7ef0ce8b31c756897521eb6c7b39796633357",
+			"02c9e16154474b3ed5b38218bb0463e008f89ee03e62d22fdcc8014beab25b48fa"},
+		{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m/0",
+			"84e9c258bb8557a40e0d041115b376dd55eda99c0042ce29e81ebe4efed9b86a",
+			"d7d065f63a62624888500cdb4f88b6d59c2927fee9e6d0cdff9cad555884df6e",
+			"039b6df4bece7b6c81e2adfeea4bcf5c8c8a6e40ea7ffa3cf6e8494c61a1fc82cc"},
+		{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m/0/2147483647'",
+			"f235b2bc5c04606ca9c30027a84f353acf4e4683edbd11f635d0dcc1cd106ea6",
+			"96d2ec9316746a75e7793684ed01e3d51194d81a42a3276858a5b7376d4b94b9",
+			"02f89c5deb1cae4fedc9905f98ae6cbf6cbab120d8cb85d5bd9a91a72f4c068c76"},
+		{"fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", "m/0/2147483647'/1/2147483646'/2",
+			"3bfb29ee8ac4484f09db09c2079b520ea5616df7820f071a20320366fbe226a7",
+			"bb0a77ba01cc31d77205d51d08bd313b979a71ef4de9b062f8958297e746bd67",
+			"020ee02e18967237cf62672983b253ee62fa4dd431f8243bfeccdf39dbe181387f"},
+		// Derivation retry: the first candidate key of m/28578'/33941 is invalid.
+		{"000102030405060708090a0b0c0d0e0f", "m/28578'",
+			"e94c8ebe30c2250a14713212f6449b20f3329105ea15b652ca5bdfc68f6c65c2",
+			"06f0db126f023755d0b8d86d4591718a5210dd8d024e3e14b6159d63f53aa669", ""},
+		{"000102030405060708090a0b0c0d0e0f", "m/28578'/33941",
+			"9e87fe95031f14736774cd82f25fd885065cb7c358c1edf813c72af535e83071",
+			"092154eed4af83e078ff9b84322015aefe5769e31270f62c3f66c33888335f3a", ""},
+		// Seed retry: the first candidate master key is invalid.
+		{"a7305bc8df8d0951f0cb224c0e95d7707cbdf2c6ce7e8d481fec69c7ff5e9446", "m",
+			"7762f9729fed06121fd13f326884c82f59aa95c57ac492ce8c9654e60efd130c",
+			"3b8c18469a4634517d6d0b65448f8e6c62091b45540a1743c5846be55d47d88f", ""},
+	}
+	for _, v := range vectors {
+		t.Run(v.seed[:8]+"/"+v.path, func(t *testing.T) {
+			seed, _ := hex.DecodeString(v.seed)
+			k, err := DerivePath(NewMasterKey(seed), v.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(k.ChainCode); got != v.chainCode {
+				t.Errorf("chain code %s, want %s", got, v.chainCode)
+			}
+			if got := fmt.Sprintf("%064x", k.PrivateKey.D); got != v.privateKey {
+				t.Errorf("private key %s, want %s", got, v.privateKey)
+			}
+			publicKey := hex.EncodeToString(elliptic.MarshalCompressed(elliptic.P256(), k.PublicKey.X, k.PublicKey.Y))
+			if v.publicKey != "" && publicKey != v.publicKey {
+				t.Errorf("public key %s, want %s", publicKey, v.publicKey)
+			}
+		})
+	}
+}
+
+// Public derivation has to land on the same keys as private derivation.
+func TestDeriveChildFromPublicKey(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	account, err := DerivePath(NewMasterKey(seed), HD_ACCOUNT_PATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := *account
+	public.PrivateKey = nil
+	for _, index := range []uint32{0, 1, 1000000000} {
+		private, _ := DeriveChild(account, index)
+		k, err := DeriveChild(&public, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.PublicKey.X.Cmp(private.PublicKey.X) != 0 || k.PublicKey.Y.Cmp(private.PublicKey.Y) != 0 {
+			t.Fatalf("child %d of the public key differs", index)
+		}
+	}
+	if _, err := DeriveChild(&public, HD_HARDENED); err == nil {
+		t.Fatalf("derived a hardened child of a public key")
+	}
+}
+
+func TestSeedFromMnemonic(t *testing.T) {
+	seed, err := SeedFromMnemonic(testMnemonic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc19a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4"
+	if got := hex.EncodeToString(seed); got != want {
+		t.Fatalf("seed %s, want %s", got, want)
+	}
+	spaced, err := SeedFromMnemonic("  abandon abandon abandon abandon abandon abandon\n abandon abandon abandon abandon abandon  about ")
+	if err != nil || hex.EncodeToString(spaced) != want {
+		t.Fatalf("extra whitespace changed the seed")
+	}
+	if _, err := SeedFromMnemonic("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"); err == nil {
+		t.Fatalf("accepted a mnemonic with a bad checksum")
+	}
+}
+
+func TestScanStopsAfterGapLimit(t *testing.T) {
+	ks, err := NewKeystore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := NewKeystoreRepository()
+	kw, _, err := kr.CreateHD(ks, "hd", "passphrase", testMnemonic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seed, _ := SeedFromMnemonic(testMnemonic)
+	account, _ := DerivePath(NewMasterKey(seed), HD_ACCOUNT_PATH)
+	address := func(chain uint32, index uint32) string {
+		a, _ := NewHDAddress(account, HD_ACCOUNT_PATH, chain, index)
+		return a.BlockchainAddress
+	}
+	if kw.BlockchainAddress != address(HD_RECEIVE, 0) {
+		t.Fatalf("wallet address is not m/0/0 of its account")
+	}
+	used := map[string]bool{
+		address(HD_RECEIVE, 0): true,
+		address(HD_RECEIVE, 5): true,
+		address(HD_CHANGE, 2):  true,
+	}
+	asked := make(map[string]bool)
+	addresses, err := kr.Scan(ks, kw.ID, func(blockchainAddress string) (bool, error) {
+		asked[blockchainAddress] = true
+		return used[blockchainAddress], nil
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each chain is asked up to HD_GAP_LIMIT addresses past its last used
+	// one and no further.
+	for _, c := range []struct{ chain, last uint32 }{{HD_RECEIVE, 5}, {HD_CHANGE, 2}} {
+		for index := uint32(0); index <= c.last+HD_GAP_LIMIT; index++ {
+			if !asked[address(c.chain, index)] {
+				t.Fatalf("scan skipped %d/%d", c.chain, index)
+			}
+		}
+		if asked[address(c.chain, c.last+HD_GAP_LIMIT+1)] {
+			t.Fatalf("scan of chain %d went past the gap limit", c.chain)
+		}
+	}
+	if len(asked) != 2*HD_GAP_LIMIT+5+2+2 {
+		t.Fatalf("scan asked about %d addresses", len(asked))
+	}
+
+	found := 0
+	for _, a := range addresses {
+		if used[a.BlockchainAddress] != a.Used {
+			t.Fatalf("%s used = %v", a.Path, a.Used)
+		}
+		if a.Used {
+			found += 1
+		}
+	}
+	if found != len(used) {
+		t.Fatalf("scan recorded %d used addresses, want %d", found, len(used))
+	}
+	next, err := kr.NewAddress(ks, kw.ID, HD_RECEIVE)
+	if err != nil || next.Index != 6 {
+		t.Fatalf("next receive address is %v, want index 6", next)
+	}
+	next, err = kr.NewAddress(ks, kw.ID, HD_CHANGE)
+	if err != nil || next.Index != 3 {
+		t.Fatalf("next change address is %v, want index 3", next)
+	}
+}
+
+// The keystore stays usable while a scan waits for its lookups.
+func TestScanDoesNotHoldKeystore(t *testing.T) {
+	ks, err := NewKeystore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := NewKeystoreRepository()
+	kw, _, err := kr.CreateHD(ks, "hd", "passphrase", testMnemonic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := true
+	_, err = kr.Scan(ks, kw.ID, func(blockchainAddress string) (bool, error) {
+		if first {
+			first = false
+			if _, err := kr.NewAddress(ks, kw.ID, HD_CHANGE); err != nil {
+				t.Errorf("derive during a scan: %v", err)
+			}
+		}
+		return false, nil
+	}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses, _ := kr.Addresses(ks, kw.ID)
+	if len(addresses) != 2 {
+		t.Fatalf("%d addresses after the scan, want the change address derived during it kept", len(addresses))
+	}
+}
@@ -0,0 +1,750 @@
+package repository
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go-blockchain/utils"
+	"go-blockchain/wallet/domain/entity"
+	"go-blockchain/wallet/domain/repository"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	KEYSTORE_VERSION    = 1
+	KEYSTORE_TYPE_HD    = "hd"
+	KEYSTORE_TYPE_WATCH = "watch"
+
+	// scrypt parameters for new files, about 100ms on a laptop.
+	KEYSTORE_SCRYPT_N = 1 << 15
+	KEYSTORE_SCRYPT_R = 8
+	KEYSTORE_SCRYPT_P = 1
+	// Files asking for more than KEYSTORE_MAX_SCRYPT_N are refused rather
+	// than tying up the server.
+	KEYSTORE_MAX_SCRYPT_N = 1 << 20
+
+	KEYSTORE_MIN_PASSPHRASE = 8
+	KEYSTORE_UNLOCK_SEC     = 300
+	KEYSTORE_MAX_UNLOCK_SEC = 3600
+	// Session tokens are KEYSTORE_TOKEN_BYTES random bytes, hex encoded.
+	KEYSTORE_TOKEN_BYTES = 32
+)
+
+var (
+	ErrWalletNotFound  = errors.New("wallet not found")
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+	ErrWalletLocked    = errors.New("wallet is locked")
+	ErrNotHD           = errors.New("not an HD wallet")
+	ErrWatchOnly       = errors.New("wallet is watch-only")
+	ErrUnknownSender   = errors.New("sender is not an address of the wallet")
+	ErrBadSession      = errors.New("invalid session token")
+	ErrWeakPassphrase  = fmt.Errorf("passphrase shorter than %d characters", KEYSTORE_MIN_PASSPHRASE)
+)
+
+// keystoreFile is the JSON stored for each wallet. Only the private key is
+// encrypted; the address is bound to it as additional data of AES-GCM.
+// HD wallets encrypt their BIP39 seed instead, and keep the account's
+// extended public key in the clear so addresses can be derived while the
+// wallet is locked. Watch-only wallets have no secret and no crypto
+// section; their public key is empty when only the address is known.
+type keystoreFile struct {
+	Version           int             `json:"version"`
+	ID                string          `json:"id"`
+	Name              string          `json:"name"`
+	Type              string          `json:"type,omitempty"`
+	BlockchainAddress string          `json:"blockchain_address"`
+	PublicKey         string          `json:"public_key"`
+	CreatedAt         int64           `json:"created_at"`
+	HD                *keystoreHD     `json:"hd,omitempty"`
+	Crypto            *keystoreCrypto `json:"crypto,omitempty"`
+}
+
+type keystoreHD struct {
+	Path        string             `json:"path"`
+	PublicKey   string             `json:"public_key"`
+	ChainCode   string             `json:"chain_code"`
+	NextReceive uint32             `json:"next_receive"`
+	NextChange  uint32             `json:"next_change"`
+	Addresses   []*keystoreAddress `json:"addresses"`
+}
+
+type keystoreAddress struct {
+	Chain             uint32 `json:"chain"`
+	Index             uint32 `json:"index"`
+	BlockchainAddress string `json:"blockchain_address"`
+	PublicKey         string `json:"public_key"`
+	Used              bool   `json:"used,omitempty"`
+}
+
+type keystoreCrypto struct {
+	Cipher     string       `json:"cipher"`
+	CipherText string       `json:"ciphertext"`
+	Nonce      string       `json:"nonce"`
+	KDF        string       `json:"kdf"`
+	KDFParams  scryptParams `json:"kdfparams"`
+}
+
+type scryptParams struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+type keystoreRepository struct{}
+
+func NewKeystoreRepository() repository.KeystoreRepository {
+	return &keystoreRepository{}
+}
+
+// NewKeystore opens dir, creating it readable by the owner only.
+func NewKeystore(dir string) (*entity.Keystore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &entity.Keystore{Dir: dir, Unlocked: make(map[string]*entity.UnlockedWallet)}, nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+func keystoreGCM(passphrase string, params *scryptParams) (cipher.AEAD, error) {
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if params.N <= 1 || params.N > KEYSTORE_MAX_SCRYPT_N || params.R < 1 || params.P < 1 || params.DKLen != 32 {
+		return nil, fmt.Errorf("unsupported scrypt parameters")
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, params.N, params.R, params.P, params.DKLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (kr *keystoreRepository) path(ks *entity.Keystore, id string) string {
+	return filepath.Join(ks.Dir, id+".json")
+}
+
+func newKeystoreFile(name string, publicKey *ecdsa.PublicKey) (*keystoreFile, error) {
+	id, err := randomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	return &keystoreFile{
+		Version:           KEYSTORE_VERSION,
+		ID:                hex.EncodeToString(id),
+		Name:              name,
+		BlockchainAddress: BlockchainAddressFromPublicKey(publicKey),
+		PublicKey:         fmt.Sprintf("%064x%064x", publicKey.X, publicKey.Y),
+		CreatedAt:         time.Now().Unix(),
+	}, nil
+}
+
+// seal encrypts secret into f with a key derived from passphrase.
+func (kr *keystoreRepository) seal(f *keystoreFile, passphrase string, secret []byte) error {
+	salt, err := randomBytes(32)
+	if err != nil {
+		return err
+	}
+	f.Crypto = &keystoreCrypto{
+		Cipher: "aes-256-gcm",
+		KDF:    "scrypt",
+		KDFParams: scryptParams{
+			N: KEYSTORE_SCRYPT_N, R: KEYSTORE_SCRYPT_R, P: KEYSTORE_SCRYPT_P,
+			DKLen: 32, Salt: hex.EncodeToString(salt),
+		},
+	}
+	gcm, err := keystoreGCM(passphrase, &f.Crypto.KDFParams)
+	if err != nil {
+		return err
+	}
+	nonce, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return err
+	}
+	f.Crypto.Nonce = hex.EncodeToString(nonce)
+	f.Crypto.CipherText = hex.EncodeToString(gcm.Seal(nil, nonce, secret, []byte(f.BlockchainAddress)))
+	return nil
+}
+
+// open decrypts the secret of f.
+func (kr *keystoreRepository) open(f *keystoreFile, passphrase string) ([]byte, error) {
+	if f.Type == KEYSTORE_TYPE_WATCH {
+		return nil, ErrWatchOnly
+	}
+	if f.Crypto == nil {
+		return nil, fmt.Errorf("keystore %s: no crypto section", f.ID)
+	}
+	gcm, err := keystoreGCM(passphrase, &f.Crypto.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err1 := hex.DecodeString(f.Crypto.Nonce)
+	ciphertext, err2 := hex.DecodeString(f.Crypto.CipherText)
+	if err1 != nil || err2 != nil || len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("keystore %s: malformed ciphertext", f.ID)
+	}
+	secret, err := gcm.Open(nil, nonce, ciphertext, []byte(f.BlockchainAddress))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return secret, nil
+}
+
+// save replaces the file of f through a rename, so a crash never leaves a
+// truncated keystore behind.
+func (kr *keystoreRepository) save(ks *entity.Keystore, f *keystoreFile) error {
+	m, _ := json.MarshalIndent(f, "", "  ")
+	tmp := kr.path(ks, f.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, m, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, kr.path(ks, f.ID))
+}
+
+// Create generates a wallet and writes it encrypted with passphrase.
+func (kr *keystoreRepository) Create(ks *entity.Keystore, name string, passphrase string) (*entity.KeystoreWallet, error) {
+	if len(passphrase) < KEYSTORE_MIN_PASSPHRASE {
+		return nil, ErrWeakPassphrase
+	}
+	w := NewWallet()
+	f, err := newKeystoreFile(name, w.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	d := make([]byte, 32)
+	w.PrivateKey.D.FillBytes(d)
+	if err := kr.seal(f, passphrase, d); err != nil {
+		return nil, err
+	}
+	ks.Mux.Lock()
+	defer ks.Mux.Unlock()
+	if err := kr.save(ks, f); err != nil {
+		return nil, err
+	}
+	return kr.describe(ks, f), nil
+}
+
+// CreateHD writes an HD wallet restored from mnemonic, or from a new
+// mnemonic when it is empty. The new mnemonic is returned so it can be
+// written down; it is not stored anywhere else.
+func (kr *keystoreRepository) CreateHD(ks *entity.Keystore, name string, passphrase string, mnemonic string) (*entity.KeystoreWallet, string, error) {
+	if len(passphrase) < KEYSTORE_MIN_PASSPHRASE {
+		return nil, "", ErrWeakPassphrase
+	}
+	generated := ""
+	if mnemonic == "" {
+		var err error
+		if mnemonic, err = NewMnemonic(); err != nil {
+			return nil, "", err
+		}
+		generated = mnemonic
+	}
+	seed, err := SeedFromMnemonic(mnemonic)
+	if err != nil {
+		return nil, "", err
+	}
+	account, err := DerivePath(NewMasterKey(seed), HD_ACCOUNT_PATH)
+	if err != nil {
+		return nil, "", err
+	}
+	first, err := NewHDAddress(account, HD_ACCOUNT_PATH, HD_RECEIVE, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	f, err := newKeystoreFile(name, utils.PublicKeyFromString(first.PublicKey))
+	if err != nil {
+		return nil, "", err
+	}
+	f.Type = KEYSTORE_TYPE_HD
+	f.HD = &keystoreHD{
+		Path:        HD_ACCOUNT_PATH,
+		PublicKey:   fmt.Sprintf("%064x%064x", account.PublicKey.X, account.PublicKey.Y),
+		ChainCode:   hex.EncodeToString(account.ChainCode),
+		NextReceive: 1,
+		Addresses: []*keystoreAddress{{
+			Chain:             first.Chain,
+			Index:             first.Index,
+			BlockchainAddress: first.BlockchainAddress,
+			PublicKey:         first.PublicKey,
+		}},
+	}
+	if err := kr.seal(f, passphrase, seed); err != nil {
+		return nil, "", err
+	}
+	ks.Mux.Lock()
+	defer ks.Mux.Unlock()
+	if err := kr.save(ks, f); err != nil {
+		return nil, "", err
+	}
+	return kr.describe(ks, f), generated, nil
+}
+
+// Watch adds a watch-only wallet for a blockchain address, a public key,
+// or both when they match. It can show balances and build unsigned
+// transactions, but never sign.
+func (kr *keystoreRepository) Watch(ks *entity.Keystore, name string, blockchainAddress string, publicKey string) (*entity.KeystoreWallet, error) {
+	if publicKey != "" {
+		b, err := hex.DecodeString(publicKey)
+		if err != nil || len(b) != 64 {
+			return nil, fmt.Errorf("invalid public key")
+		}
+		pk := utils.PublicKeyFromString(publicKey)
+		if !pk.Curve.IsOnCurve(pk.X, pk.Y) {
+			return nil, fmt.Errorf("invalid public key")
+		}
+		address := BlockchainAddressFromPublicKey(pk)
+		if blockchainAddress != "" && blockchainAddress != address {
+			return nil, fmt.Errorf("public key does not match %s", blockchainAddress)
+		}
+		blockchainAddress = address
+	}
+	if !ValidBlockchainAddress(blockchainAddress) {
+		return nil, fmt.Errorf("invalid blockchain address %q", blockchainAddress)
+	}
+	id, err := randomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	f := &keystoreFile{
+		Version:           KEYSTORE_VERSION,
+		ID:                hex.EncodeToString(id),
+		Name:              name,
+		Type:              KEYSTORE_TYPE_WATCH,
+		BlockchainAddress: blockchainAddress,
+		PublicKey:         strings.ToLower(publicKey),
+		CreatedAt:         time.Now().Unix(),
+	}
+	ks.Mux.Lock()
+	defer ks.Mux.Unlock()
+	if err := kr.save(ks, f); err != nil {
+		return nil, err
+	}
+	return kr.describe(ks, f), nil
+}
+
+// Get describes wallet id.
+func (kr *keystoreRepository) Get(ks *entity.Keystore, id string) (*entity.KeystoreWallet, error) {
+	ks.Mux.Lock()
+	defer ks.Mux.Unlock()
+	f, err := kr.read(ks, id)
+	if err != nil {
+		return nil, err
+	}
+	return kr.describe(ks, f), nil
+}
+
+func (kr *keystoreRepository) read(ks *entity.Keystore, id string) (*keystoreFile, error) {
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return nil, ErrWalletNotFound
+	}
+	data, err := ioutil.ReadFile(kr.path(ks, id))
+	if os.IsNotExist(err) {
+		return nil, ErrWalletNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f keystoreFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("keystore %s: %v", id, err)
+	}
+	if f.Version != KEYSTORE_VERSION || f.ID != id {
+		return nil, fmt.Errorf("keystore %s: unsupported file", id)
+	}
+	return &f, nil
+}
+
+// describe must be called with ks.Mux held or before the wallet is shared.
+func (kr *keystoreRepository) describe(ks *entity.Keystore, f *keystoreFile) *entity.KeystoreWallet {
+	kw := &entity.KeystoreWallet{
+		ID:                f.ID,
+		Name:              f.Name,
+		BlockchainAddress: f.BlockchainAddress,
+		PublicKey:         f.PublicKey,
+		CreatedAt:         f.CreatedAt,
+		Type:              f.Type,
+	}
+	if f.HD != nil {
+		kw.Path = f.HD.Path
+	}
+	if u, ok := ks.Unlocked[f.ID]; ok {
+		kw.UnlockedUntil = u.Until.Unix()
+	}
+	return kw
+}
+
+// List describes every wallet file in the keystore, oldest first.
+func (kr *keystoreRepository) List(ks *entity.Keystore) ([]*entity.KeystoreWallet, error) {
+	names, err := filepath.Glob(filepath.Join(ks.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	ks.Mux.Lock()
+	defer ks.Mux.Unlock()
+	wallets := make([]*entity.KeystoreWallet, 0, len(names))
+	for _, name := range names {
+		f, err := kr.read(ks, strings.TrimSuffix(filepath.Base(name), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, kr.describe(ks, f))
+	}
+	sort.Slice(wallets, func(i, j int) bool { return wallets[i].CreatedAt < wallets[j].CreatedAt })
+	return wallets, nil
+}
+
+// Unlock decrypts wallet id and keeps it in memory for timeout, at most
+// KEYSTORE_MAX_UNLOCK_SEC. It returns the session token Sign asks for.
+// Unlocking an unlocked wallet extends it under a new token.
+func (kr *keystoreRepository) Unlock(ks *entity.Keystore, id string, passphrase string, timeout time.Duration) (*entity.KeystoreWallet, string, error) {
+	f, err := kr.read(ks, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if timeout <= 0 {
+		timeout = KEYSTORE_UNLOCK_SEC * time.Second
+	}
+	if timeout > KEYSTORE_MAX_UNLOCK_SEC*time.Second {
+		timeout = KEYSTORE_MAX_UNLOCK_SEC * time.Second
+	}
+
+	secret, err := kr.open(f, passphrase)
+	if err != nil {
+		return nil, "", err
+	}
+	u := &entity.UnlockedWallet{Until: time.Now().Add(timeout)}
+	if f.Type == KEYSTORE_TYPE_HD {
+		u.Account, err = kr.account(f, secret)
+		for i := range secret {
+			secret[i] = 0
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		k, err := DerivePath(u.Account, fmt.Sprintf("m/%d/%d", HD_RECEIVE, 0))
+		if err != nil {
+			return nil, "", err
+		}
+		u.Wallet = NewWalletFromPrivateKey(k.PrivateKey)
+	} else {
+		u.Wallet = NewWalletFromPrivateKey(privateKeyFromBytes(secret))
+	}
+	if u.Wallet.BlockchainAddress != f.BlockchainAddress {
+		return nil, "", fmt.Errorf("keystore %s: key does not match the address", id)
+	}
+	token := make([]byte, KEYSTORE_TOKEN_BYTES)
+	if _, err := rand.Read(token); err != nil {
+		return nil, "", err
+	}
+	u.Token = hex.EncodeToString(token)
+
+	ks.Mux.Lock()
+	defer ks.Mux.Unlock()
+	kr.lock(ks, id)
+	u.Timer = time.AfterFunc(timeout, func() {
+		ks.Mux.Lock()
+		defer ks.Mux.Unlock()
+		if ks.Unlocked[id] == u {
+			kr.lock(ks, id)
+		}
+	})
+	ks.Unlocked[id] = u
+	return kr.describe(ks, f), u.Token, nil
+}
+
+// Lock forgets the key of wallet id and reports whether it was unlocked.
+func (kr *keystoreRepository) Lock(ks *entity.Keystore, id string) bool {
+	ks.Mux.Lock()
+	defer ks.Mux.Unlock()
+	return kr.lock(ks, id)
+}
+
+// lock must be called with ks.Mux held.
+func (kr *keystoreRepository) lock(ks *entity.Keystore, id string) bool {
+	u, ok := ks.Unlocked[id]
+	if !ok {
+		return false
+	}
+	u.Timer.Stop()
+	u.Wallet.PrivateKey.D.SetInt64(0)
+	if u.Account != nil {
+		u.Account.PrivateKey.D.SetInt64(0)
+	}
+	delete(ks.Unlocked, id)
+	return true
+}
+
+// account rebuilds the account key of an HD wallet from its seed and
+// checks it against the extended public key in f.
+func (kr *keystoreRepository) account(f *keystoreFile, seed []byte) (*entity.HDKey, error) {
+	account, err := DerivePath(NewMasterKey(seed), f.HD.Path)
+	if err != nil {
+		return nil, err
+	}
+	if fmt.Sprintf("%064x%064x", account.PublicKey.X, account.PublicKey.Y) != f.HD.PublicKey ||
+		hex.EncodeToString(account.ChainCode) != f.HD.ChainCode {
+		return nil, fmt.Errorf("keystore %s: seed does not match the account key", f.ID)
+	}
+	return account, nil
+}
+
+// publicAccount is the account key of an HD wallet without its private
+// half.
+func (kr *keystoreRepository) publicAccount(f *keystoreFile) (*entity.HDKey, error) {
+	if f.HD == nil {
+		return nil, ErrNotHD
+	}
+	chainCode, err := hex.DecodeString(f.HD.ChainCode)
+	if err != nil || len(chainCode) != 32 {
+		return nil, fmt.Errorf("keystore %s: malformed chain code", f.ID)
+	}
+	return &entity.HDKey{PublicKey: utils.PublicKeyFromString(f.HD.PublicKey), ChainCode: chainCode}, nil
+}
+
+func (kr *keystoreRepository) hdAddress(f *keystoreFile, a *keystoreAddress) *entity.HDAddress {
+	return &entity.HDAddress{
+		Chain:             a.Chain,
+		Index:             a.Index,
+		Path:              fmt.Sprintf("%s/%d/%d", f.HD.Path, a.Chain, a.Index),
+		BlockchainAddress: a.BlockchainAddress,
+		PublicKey:         a.PublicKey,
+		Used:              a.Used,
+	}
+}
+
+// Addresses lists the addresses derived so far for HD wallet id.
+func (kr *keystoreRepository) Addresses(ks *entity.Keystore, id string) ([]*entity.HDAddress, error) {
+	ks.Mux.Lock()
+	defer ks.Mux.Unlock()
+	f, err := kr.read(ks, id)
+	if err != nil {
+		return nil, err
+	}
+	if f.HD == nil {
+		return nil, ErrNotHD
+	}
+	addresses := make([]*entity.HDAddress, 0, len(f.HD.Addresses))
+	for _, a := range f.HD.Addresses {
+		addresses = append(addresses, kr.hdAddress(f, a))
+	}
+	return addresses, nil
+}
+
+// NewAddress derives the next address on chain, HD_RECEIVE or HD_CHANGE,
+// of HD wallet id. It works while the wallet is locked.
+func (kr *keystoreRepository) NewAddress(ks *entity.Keystore, id string, chain uint32) (*entity.HDAddress, error) {
+	if chain != HD_RECEIVE && chain != HD_CHANGE {
+		return nil, fmt.Errorf("invalid chain %d", chain)
+	}
+	ks.Mux.Lock()
+	defer ks.Mux.Unlock()
+	f, err := kr.read(ks, id)
+	if err != nil {
+		return nil, err
+	}
+	account, err := kr.publicAccount(f)
+	if err != nil {
+		return nil, err
+	}
+	next := &f.HD.NextReceive
+	if chain == HD_CHANGE {
+		next = &f.HD.NextChange
+	}
+	a, err := kr.derive(f, account, chain, *next)
+	if err != nil {
+		return nil, err
+	}
+	*next += 1
+	if err := kr.save(ks, f); err != nil {
+		return nil, err
+	}
+	return kr.hdAddress(f, a), nil
+}
+
+// derive returns address chain/index of f, adding it to f.HD.Addresses
+// when it has not been derived before.
+func (kr *keystoreRepository) derive(f *keystoreFile, account *entity.HDKey, chain uint32, index uint32) (*keystoreAddress, error) {
+	for _, a := range f.HD.Addresses {
+		if a.Chain == chain && a.Index == index {
+			return a, nil
+		}
+	}
+	hda, err := NewHDAddress(account, f.HD.Path, chain, index)
+	if err != nil {
+		return nil, err
+	}
+	a := &keystoreAddress{Chain: chain, Index: index, BlockchainAddress: hda.BlockchainAddress, PublicKey: hda.PublicKey}
+	f.HD.Addresses = append(f.HD.Addresses, a)
+	return a, nil
+}
+
+// Scan walks both chains of HD wallet id until gapLimit addresses in a row
+// are not in used, records every used address, and moves the next index
+// past the last one. This restores a wallet recreated from its mnemonic.
+// used typically asks a gateway, so ks.Mux is not held while it runs.
+func (kr *keystoreRepository) Scan(ks *entity.Keystore, id string, used func(blockchainAddress string) (bool, error), gapLimit int) ([]*entity.HDAddress, error) {
+	if gapLimit <= 0 {
+		gapLimit = HD_GAP_LIMIT
+	}
+	ks.Mux.Lock()
+	f, err := kr.read(ks, id)
+	var account *entity.HDKey
+	if err == nil {
+		account, err = kr.publicAccount(f)
+	}
+	ks.Mux.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[uint32][]uint32)
+	for _, chain := range []uint32{HD_RECEIVE, HD_CHANGE} {
+		for index, gap := uint32(0), 0; gap < gapLimit; index++ {
+			hda, err := NewHDAddress(account, f.HD.Path, chain, index)
+			if err != nil {
+				return nil, err
+			}
+			isUsed, err := used(hda.BlockchainAddress)
+			if err != nil {
+				return nil, err
+			}
+			if !isUsed {
+				gap += 1
+				continue
+			}
+			gap = 0
+			found[chain] = append(found[chain], index)
+		}
+	}
+
+	ks.Mux.Lock()
+	defer ks.Mux.Unlock()
+	// Read again so that addresses derived meanwhile are kept.
+	if f, err = kr.read(ks, id); err != nil {
+		return nil, err
+	}
+	for _, chain := range []uint32{HD_RECEIVE, HD_CHANGE} {
+		next := &f.HD.NextReceive
+		if chain == HD_CHANGE {
+			next = &f.HD.NextChange
+		}
+		for _, index := range found[chain] {
+			a, err := kr.derive(f, account, chain, index)
+			if err != nil {
+				return nil, err
+			}
+			a.Used = true
+			if *next <= index {
+				*next = index + 1
+			}
+		}
+	}
+	if err := kr.save(ks, f); err != nil {
+		return nil, err
+	}
+	addresses := make([]*entity.HDAddress, 0, len(f.HD.Addresses))
+	for _, a := range f.HD.Addresses {
+		addresses = append(addresses, kr.hdAddress(f, a))
+	}
+	return addresses, nil
+}
+
+// accountChild is the chain and index of path, an address path below the
+// account path of an HD wallet.
+func accountChild(accountPath string, path string) (uint32, uint32, bool) {
+	if !strings.HasPrefix(path, accountPath+"/") {
+		return 0, 0, false
+	}
+	indexes, err := ParsePath("m/" + strings.TrimPrefix(path, accountPath+"/"))
+	if err != nil || len(indexes) != 2 ||
+		(indexes[0] != HD_RECEIVE && indexes[0] != HD_CHANGE) || indexes[1] >= HD_HARDENED {
+		return 0, 0, false
+	}
+	return indexes[0], indexes[1], true
+}
+
+// Sign builds a payment from wallet id and signs it with the unlocked key,
+// given the session token of the unlock. HD wallets can send from any
+// address they have derived, or from the one at path; sender selects it
+// and defaults to the wallet address. The key is only used under ks.Mux,
+// so Lock cannot zero it mid-signature.
+func (kr *keystoreRepository) Sign(ks *entity.Keystore, tr repository.TransactionRepository, id string, token string, sender string, path string, recipient string, value float32) (*entity.Transaction, *utils.Signature, error) {
+	ks.Mux.Lock()
+	defer ks.Mux.Unlock()
+	u, ok := ks.Unlocked[id]
+	if !ok {
+		f, err := kr.read(ks, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if f.Type == KEYSTORE_TYPE_WATCH {
+			return nil, nil, ErrWatchOnly
+		}
+		return nil, nil, ErrWalletLocked
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(u.Token)) != 1 {
+		return nil, nil, ErrBadSession
+	}
+	w := u.Wallet
+	if sender != "" && sender != w.BlockchainAddress {
+		if u.Account == nil {
+			return nil, nil, ErrUnknownSender
+		}
+		f, err := kr.read(ks, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		var chain, index uint32
+		found := false
+		for _, a := range f.HD.Addresses {
+			if a.BlockchainAddress == sender {
+				chain, index, found = a.Chain, a.Index, true
+				break
+			}
+		}
+		if !found && path != "" {
+			chain, index, found = accountChild(f.HD.Path, path)
+		}
+		if !found {
+			return nil, nil, ErrUnknownSender
+		}
+		k, err := DerivePath(u.Account, fmt.Sprintf("m/%d/%d", chain, index))
+		if err != nil {
+			return nil, nil, err
+		}
+		w = NewWalletFromPrivateKey(k.PrivateKey)
+		defer w.PrivateKey.D.SetInt64(0)
+		if w.BlockchainAddress != sender {
+			return nil, nil, ErrUnknownSender
+		}
+	}
+	t := NewTransaction(w.PrivateKey, w.PublicKey, w.BlockchainAddress, recipient, value)
+	signature := tr.GenerateSignature(t)
+	t.SenderPrivateKey = nil
+	return t, signature, nil
+}
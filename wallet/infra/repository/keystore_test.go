@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"go-blockchain/utils"
+	"go-blockchain/wallet/domain/entity"
+	"go-blockchain/wallet/domain/repository"
+)
+
+const (
+	testPassphrase = "passphrase"
+	testRecipient  = "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"
+)
+
+func newTestKeystore(t *testing.T) (*entity.Keystore, *keystoreRepository) {
+	t.Helper()
+	ks, err := NewKeystore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks, NewKeystoreRepository().(*keystoreRepository)
+}
+
+func verifySigned(t *testing.T, tx *entity.Transaction, signature *utils.Signature) {
+	t.Helper()
+	h := utils.TransactionSigningHash(tx.SenderBlockchainAddress, tx.RecipientBlockchainAddress, tx.Value)
+	if !ecdsa.Verify(tx.SenderPublicKey, h[:], signature.R, signature.S) {
+		t.Fatalf("signature does not verify")
+	}
+	if BlockchainAddressFromPublicKey(tx.SenderPublicKey) != tx.SenderBlockchainAddress {
+		t.Fatalf("signed with a key of another address")
+	}
+}
+
+func TestKeystoreSignRoundTrip(t *testing.T) {
+	ks, kr := newTestKeystore(t)
+	for _, hd := range []bool{false, true} {
+		var kw *entity.KeystoreWallet
+		var err error
+		if hd {
+			kw, _, err = kr.CreateHD(ks, "hd", testPassphrase, "")
+		} else {
+			kw, err = kr.Create(ks, "single", testPassphrase)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := kr.Sign(ks, NewTransactionRepository(), kw.ID, "", "", "", testRecipient, 1); err != ErrWalletLocked {
+			t.Fatalf("signed with a locked wallet: %v", err)
+		}
+		_, token, err := kr.Unlock(ks, kw.ID, testPassphrase, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, signature, err := kr.Sign(ks, NewTransactionRepository(), kw.ID, token, "", "", testRecipient, 1.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx.SenderBlockchainAddress != kw.BlockchainAddress || tx.Value != 1.5 || tx.SenderPrivateKey != nil {
+			t.Fatalf("signed %+v", tx)
+		}
+		verifySigned(t, tx, signature)
+		if !kr.Lock(ks, kw.ID) {
+			t.Fatalf("wallet was not unlocked")
+		}
+	}
+}
+
+func TestKeystoreSignHDAddress(t *testing.T) {
+	ks, kr := newTestKeystore(t)
+	kw, _, err := kr.CreateHD(ks, "hd", testPassphrase, testMnemonic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, token, err := kr.Unlock(ks, kw.ID, testPassphrase, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, _ := SeedFromMnemonic(testMnemonic)
+	account, _ := DerivePath(NewMasterKey(seed), HD_ACCOUNT_PATH)
+	a, _ := NewHDAddress(account, HD_ACCOUNT_PATH, HD_CHANGE, 7)
+
+	tr := NewTransactionRepository()
+	if _, _, err := kr.Sign(ks, tr, kw.ID, token, a.BlockchainAddress, "", testRecipient, 1); err != ErrUnknownSender {
+		t.Fatalf("signed for an address the wallet has not derived: %v", err)
+	}
+	tx, signature, err := kr.Sign(ks, tr, kw.ID, token, a.BlockchainAddress, a.Path, testRecipient, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifySigned(t, tx, signature)
+	if tx.SenderBlockchainAddress != a.BlockchainAddress {
+		t.Fatalf("signed from %s, want %s", tx.SenderBlockchainAddress, a.BlockchainAddress)
+	}
+	other, _ := NewHDAddress(account, HD_ACCOUNT_PATH, HD_CHANGE, 8)
+	if _, _, err := kr.Sign(ks, tr, kw.ID, token, a.BlockchainAddress, other.Path, testRecipient, 1); err != ErrUnknownSender {
+		t.Fatalf("signed with the path of another address: %v", err)
+	}
+}
+
+func TestKeystoreWrongPassphrase(t *testing.T) {
+	ks, kr := newTestKeystore(t)
+	kw, err := kr.Create(ks, "single", testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := kr.Unlock(ks, kw.ID, "wrong passphrase", 0); err != ErrWrongPassphrase {
+		t.Fatalf("unlock with a wrong passphrase: %v", err)
+	}
+	if _, err := kr.Create(ks, "weak", "short"); err != ErrWeakPassphrase {
+		t.Fatalf("create with a short passphrase: %v", err)
+	}
+}
+
+// The address is the associated data of the ciphertext, so a key moved into
+// another wallet's file does not decrypt there.
+func TestKeystoreCiphertextBoundToAddress(t *testing.T) {
+	ks, kr := newTestKeystore(t)
+	a, err := kr.Create(ks, "a", testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := kr.Create(ks, "b", testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fa, err := kr.read(ks, a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb, err := kr.read(ks, b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb.Crypto = fa.Crypto
+	if err := kr.save(ks, fb); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := kr.Unlock(ks, b.ID, testPassphrase, 0); err != ErrWrongPassphrase {
+		t.Fatalf("unlocked b with a's ciphertext: %v", err)
+	}
+	if _, _, err := kr.Unlock(ks, a.ID, testPassphrase, 0); err != nil {
+		t.Fatalf("a no longer unlocks: %v", err)
+	}
+}
+
+func TestKeystoreUnlockExpires(t *testing.T) {
+	ks, kr := newTestKeystore(t)
+	kw, err := kr.Create(ks, "single", testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlocked, token, err := kr.Unlock(ks, kw.ID, testPassphrase, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unlocked.UnlockedUntil == 0 {
+		t.Fatalf("unlocked wallet reports no deadline")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, _, err := kr.Sign(ks, NewTransactionRepository(), kw.ID, token, "", "", testRecipient, 1); err != ErrWalletLocked {
+		t.Fatalf("signed after the unlock expired: %v", err)
+	}
+	if got, _ := kr.Get(ks, kw.ID); got.UnlockedUntil != 0 {
+		t.Fatalf("expired wallet still reports a deadline")
+	}
+}
+
+func TestKeystoreSessionToken(t *testing.T) {
+	ks, kr := newTestKeystore(t)
+	kw, err := kr.Create(ks, "single", testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, stale, err := kr.Unlock(ks, kw.ID, testPassphrase, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unlocking again hands out a new token and retires the old one.
+	_, token, err := kr.Unlock(ks, kw.ID, testPassphrase, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == stale {
+		t.Fatalf("unlock reused the session token")
+	}
+	var tr repository.TransactionRepository = NewTransactionRepository()
+	for _, bad := range []string{stale, "", token[:len(token)-1], "00" + token[2:]} {
+		if _, _, err := kr.Sign(ks, tr, kw.ID, bad, "", "", testRecipient, 1); err != ErrBadSession {
+			t.Fatalf("signed with token %q: %v", bad, err)
+		}
+	}
+	if _, _, err := kr.Sign(ks, tr, kw.ID, token, "", "", testRecipient, 1); err != nil {
+		t.Fatalf("refused the current token: %v", err)
+	}
+}
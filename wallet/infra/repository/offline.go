@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"go-blockchain/utils"
+	"go-blockchain/wallet/domain/entity"
+)
+
+const OFFLINE_TRANSACTION_VERSION = 1
+
+// offlineTransactionJSON is the exchange format. It travels base64 encoded
+// so it survives copy and paste, QR codes and removable media.
+type offlineTransactionJSON struct {
+	Version                    int     `json:"version"`
+	SenderBlockchainAddress    string  `json:"sender_blockchain_address"`
+	Path                       string  `json:"path,omitempty"`
+	RecipientBlockchainAddress string  `json:"recipient_blockchain_address"`
+	Value                      float32 `json:"value"`
+	SigningHash                string  `json:"signing_hash"`
+	SenderPublicKey            string  `json:"sender_public_key,omitempty"`
+	Signature                  string  `json:"signature,omitempty"`
+}
+
+// NewOfflineTransaction is an unsigned payment of value from sender to
+// recipient.
+func NewOfflineTransaction(sender string, recipient string, value float32) *entity.OfflineTransaction {
+	return &entity.OfflineTransaction{
+		Version:                    OFFLINE_TRANSACTION_VERSION,
+		SenderBlockchainAddress:    sender,
+		RecipientBlockchainAddress: recipient,
+		Value:                      value,
+		SigningHash:                utils.TransactionSigningHash(sender, recipient, value),
+	}
+}
+
+// EncodeOfflineTransaction is the base64 exchange form of ot.
+func EncodeOfflineTransaction(ot *entity.OfflineTransaction) string {
+	v := offlineTransactionJSON{
+		Version:                    ot.Version,
+		SenderBlockchainAddress:    ot.SenderBlockchainAddress,
+		Path:                       ot.Path,
+		RecipientBlockchainAddress: ot.RecipientBlockchainAddress,
+		Value:                      ot.Value,
+		SigningHash:                hex.EncodeToString(ot.SigningHash[:]),
+	}
+	if ot.SenderPublicKey != nil {
+		v.SenderPublicKey = fmt.Sprintf("%064x%064x", ot.SenderPublicKey.X, ot.SenderPublicKey.Y)
+	}
+	if ot.Signature != nil {
+		v.Signature = ot.Signature.String()
+	}
+	m, _ := json.Marshal(v)
+	return base64.StdEncoding.EncodeToString(m)
+}
+
+// DecodeOfflineTransaction accepts the base64 form or the bare JSON. It
+// checks that the signing hash matches the payment and, when the
+// transaction is signed, that the key belongs to the sender and the
+// signature verifies.
+func DecodeOfflineTransaction(s string) (*entity.OfflineTransaction, error) {
+	data := []byte(strings.TrimSpace(s))
+	if !bytes.HasPrefix(data, []byte("{")) {
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(string(data)); err != nil {
+			return nil, fmt.Errorf("offline transaction: %v", err)
+		}
+	}
+	var v offlineTransactionJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("offline transaction: %v", err)
+	}
+	if v.Version != OFFLINE_TRANSACTION_VERSION {
+		return nil, fmt.Errorf("offline transaction: unsupported version %d", v.Version)
+	}
+	if v.SenderBlockchainAddress == "" || v.RecipientBlockchainAddress == "" || v.Value <= 0 {
+		return nil, errors.New("offline transaction: missing field(s)")
+	}
+	ot := NewOfflineTransaction(v.SenderBlockchainAddress, v.RecipientBlockchainAddress, v.Value)
+	ot.Path = v.Path
+	if hex.EncodeToString(ot.SigningHash[:]) != v.SigningHash {
+		return nil, errors.New("offline transaction: signing hash does not match the payment")
+	}
+	if v.SenderPublicKey == "" && v.Signature == "" {
+		return ot, nil
+	}
+
+	publicKey, ok1 := decodePair(v.SenderPublicKey)
+	signature, ok2 := decodePair(v.Signature)
+	if !ok1 || !ok2 {
+		return nil, errors.New("offline transaction: malformed public key or signature")
+	}
+	ot.SenderPublicKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: publicKey[0], Y: publicKey[1]}
+	ot.Signature = &utils.Signature{R: signature[0], S: signature[1]}
+	if !elliptic.P256().IsOnCurve(ot.SenderPublicKey.X, ot.SenderPublicKey.Y) ||
+		BlockchainAddressFromPublicKey(ot.SenderPublicKey) != ot.SenderBlockchainAddress {
+		return nil, errors.New("offline transaction: public key does not belong to the sender")
+	}
+	if !ecdsa.Verify(ot.SenderPublicKey, ot.SigningHash[:], ot.Signature.R, ot.Signature.S) {
+		return nil, errors.New("offline transaction: invalid signature")
+	}
+	return ot, nil
+}
+
+// decodePair splits 128 hex digits into two 256 bit integers.
+func decodePair(s string) ([2]*big.Int, bool) {
+	var pair [2]*big.Int
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 64 {
+		return pair, false
+	}
+	pair[0] = new(big.Int).SetBytes(b[:32])
+	pair[1] = new(big.Int).SetBytes(b[32:])
+	return pair, true
+}
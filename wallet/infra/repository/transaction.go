@@ -3,7 +3,6 @@ package repository
 import (
 	"crypto/ecdsa"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/json"
 
 	"go-blockchain/utils"
@@ -23,8 +22,7 @@ func NewTransaction(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey,
 }
 
 func (tr *transactionRepository) GenerateSignature(t *entity.Transaction) *utils.Signature {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
+	h := utils.TransactionSigningHash(t.SenderBlockchainAddress, t.RecipientBlockchainAddress, t.Value)
 	r, s, _ := ecdsa.Sign(rand.Reader, t.SenderPrivateKey, h[:])
 	return &utils.Signature{R: r, S: s}
 }
@@ -23,14 +23,26 @@ func NewWalletRepository() repository.WalletRepository {
 
 func NewWallet() *entity.Wallet {
 	// 1. Creating ECDSA private key (32 bytes) public key (64 bytes)
-	w := new(entity.Wallet)
 	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return NewWalletFromPrivateKey(privateKey)
+}
+
+// NewWalletFromPrivateKey derives the public key and blockchain address of
+// an existing key.
+func NewWalletFromPrivateKey(privateKey *ecdsa.PrivateKey) *entity.Wallet {
+	w := new(entity.Wallet)
 	w.PrivateKey = privateKey
 	w.PublicKey = &w.PrivateKey.PublicKey
+	w.BlockchainAddress = BlockchainAddressFromPublicKey(w.PublicKey)
+	return w
+}
+
+// BlockchainAddressFromPublicKey is the base58check address of publicKey.
+func BlockchainAddressFromPublicKey(publicKey *ecdsa.PublicKey) string {
 	// 2. Perform SHA-256 hashing on the public key (32 bytes).
 	h2 := sha256.New()
-	h2.Write(w.PublicKey.X.Bytes())
-	h2.Write(w.PublicKey.Y.Bytes())
+	h2.Write(publicKey.X.Bytes())
+	h2.Write(publicKey.Y.Bytes())
 	digest2 := h2.Sum(nil)
 	// 3. Perform RIPEMD-160 hashing on the result of SHA-256 (20 bytes).
 	h3 := ripemd160.New()
@@ -55,9 +67,14 @@ func NewWallet() *entity.Wallet {
 	copy(dc8[:21], vd4[:])
 	copy(dc8[21:], chsum[:])
 	// 9. Convert the result from a byte string into base58.
-	address := base58.Encode(dc8)
-	w.BlockchainAddress = address
-	return w
+	return base58.Encode(dc8)
+}
+
+// ValidBlockchainAddress reports whether address is a base58check address
+// as made by BlockchainAddressFromPublicKey.
+func ValidBlockchainAddress(address string) bool {
+	b, version, err := base58.CheckDecode(address)
+	return err == nil && version == 0x00 && len(b) == ripemd160.Size
 }
 
 func (wr *walletRepository) PrivateKey(w *entity.Wallet) *ecdsa.PrivateKey {
@@ -2,14 +2,19 @@ package repository
 
 import (
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"path"
+	"sort"
 	"strconv"
 	"text/template"
+	"time"
 
 	blockchainRequest "go-blockchain/blockchain/infra/http/request"
 	"go-blockchain/blockchain/infra/http/response"
@@ -17,9 +22,14 @@ import (
 	"go-blockchain/wallet/domain/entity"
 	"go-blockchain/wallet/domain/repository"
 	walletRequest "go-blockchain/wallet/infra/http/request"
+	walletResponse "go-blockchain/wallet/infra/http/response"
 )
 
-const tempDir = "templates"
+const (
+	tempDir = "templates"
+	// HISTORY_EXPORT_PAGE_SIZE transfers are fetched at a time for CSV.
+	HISTORY_EXPORT_PAGE_SIZE = 500
+)
 
 type walletServerRepository struct{}
 
@@ -39,6 +49,24 @@ func (wsr walletServerRepository) Gateway(ws *entity.WalletServer) string {
 	return ws.Gateway
 }
 
+// gatewayClient trusts only GatewayCAFile when it is set, so an HTTPS
+// gateway has to present a certificate from the pinned CA.
+func (wsr walletServerRepository) gatewayClient(ws *entity.WalletServer) *http.Client {
+	if ws.GatewayClient != nil {
+		return ws.GatewayClient
+	}
+	client := &http.Client{}
+	if ws.GatewayCAFile != "" {
+		tlsConfig, err := utils.ClientTLSConfig("", "", ws.GatewayCAFile)
+		if err != nil {
+			log.Fatalf("ERROR: %v", err)
+		}
+		client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	ws.GatewayClient = client
+	return client
+}
+
 func (wsr walletServerRepository) Index(ws *entity.WalletServer, w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
@@ -49,7 +77,14 @@ func (wsr walletServerRepository) Index(ws *entity.WalletServer, w http.Response
 	}
 }
 
-func (wsr walletServerRepository) Wallet(wr repository.WalletRepository, w http.ResponseWriter, req *http.Request) {
+// Wallet hands a fresh key pair, private key included, to the browser. It
+// is only served with -insecure-raw-keys; the keystore endpoints replace it.
+func (wsr walletServerRepository) Wallet(ws *entity.WalletServer, wr repository.WalletRepository, w http.ResponseWriter, req *http.Request) {
+	if !ws.InsecureRawKeys {
+		log.Println("ERROR: raw private keys are disabled, use /wallets or -insecure-raw-keys")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 	switch req.Method {
 	case http.MethodPost:
 		w.Header().Add("Content-Type", "application/json")
@@ -62,7 +97,11 @@ func (wsr walletServerRepository) Wallet(wr repository.WalletRepository, w http.
 	}
 }
 
-func (wsr walletServerRepository) CreateTransaction(ws *entity.WalletServer, tr repository.TransactionRepository, w http.ResponseWriter, req *http.Request) {
+// CreateTransaction signs with the unlocked keystore wallet named by
+// wallet_id, given the session_token of its unlock, or with
+// sender_private_key when raw keys are allowed, and forwards the signed
+// transaction to the gateway.
+func (wsr walletServerRepository) CreateTransaction(ws *entity.WalletServer, tr repository.TransactionRepository, kr repository.KeystoreRepository, w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodPost:
 		decoder := json.NewDecoder(req.Body)
@@ -78,9 +117,14 @@ func (wsr walletServerRepository) CreateTransaction(ws *entity.WalletServer, tr
 			io.WriteString(w, string(utils.JsonStatus("fail")))
 			return
 		}
+		w.Header().Add("Content-Type", "application/json")
+		if t.RawKey() && !ws.InsecureRawKeys {
+			log.Println("ERROR: raw private keys are disabled, use wallet_id or -insecure-raw-keys")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
 
-		publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
-		privateKey := utils.PrivateKeyFromString(*t.SenderPrivateKey, publicKey)
 		value, err := strconv.ParseFloat(*t.Value, 32)
 		if err != nil {
 			log.Println("ERROR: parse error")
@@ -89,93 +133,884 @@ func (wsr walletServerRepository) CreateTransaction(ws *entity.WalletServer, tr
 		}
 		value32 := float32(value)
 
-		w.Header().Add("Content-Type", "application/json")
+		var transaction *entity.Transaction
+		var signature *utils.Signature
+		if t.RawKey() {
+			publicKey := utils.PublicKeyFromString(*t.SenderPublicKey)
+			privateKey := utils.PrivateKeyFromString(*t.SenderPrivateKey, publicKey)
+			transaction = NewTransaction(privateKey, publicKey,
+				*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, value32)
+			signature = tr.GenerateSignature(transaction)
+		} else {
+			sender := ""
+			if t.SenderBlockchainAddress != nil {
+				sender = *t.SenderBlockchainAddress
+			}
+			transaction, signature, err = kr.Sign(ws.Keystore, tr, *t.WalletID, *t.SessionToken, sender, "", *t.RecipientBlockchainAddress, value32)
+			if err != nil {
+				log.Printf("ERROR: sign with %s: %v", *t.WalletID, err)
+				switch err {
+				case ErrWalletNotFound:
+					w.WriteHeader(http.StatusNotFound)
+				case ErrWalletLocked:
+					w.WriteHeader(http.StatusForbidden)
+				case ErrBadSession:
+					w.WriteHeader(http.StatusUnauthorized)
+				case ErrUnknownSender, ErrWatchOnly:
+					w.WriteHeader(http.StatusBadRequest)
+				default:
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
+		}
+		if err := wsr.postTransaction(ws, transaction, signature); err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		io.WriteString(w, string(utils.JsonStatus("success")))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+// postTransaction hands a signed transaction to the gateway.
+func (wsr walletServerRepository) postTransaction(ws *entity.WalletServer, t *entity.Transaction, signature *utils.Signature) error {
+	signatureStr := signature.String()
+	publicKeyStr := fmt.Sprintf("%064x%064x", t.SenderPublicKey.X, t.SenderPublicKey.Y)
+	bt := &blockchainRequest.TransactionRequest{
+		SenderBlockchainAddress:    &t.SenderBlockchainAddress,
+		RecipientBlockchainAddress: &t.RecipientBlockchainAddress,
+		SenderPublicKey:            &publicKeyStr,
+		Value:                      &t.Value, Signature: &signatureStr,
+	}
+	m, _ := json.Marshal(bt)
+	buf := bytes.NewBuffer(m)
+
+	resp, err := wsr.gatewayClient(ws).Post(wsr.Gateway(ws)+"/transactions", "application/json", buf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 201 {
+		return fmt.Errorf("gateway refused the transaction: %s", resp.Status)
+	}
+	return nil
+}
+
+func offlineTransactionResponse(ot *entity.OfflineTransaction) *walletResponse.OfflineTransactionResponse {
+	return &walletResponse.OfflineTransactionResponse{
+		Transaction:                EncodeOfflineTransaction(ot),
+		SenderBlockchainAddress:    ot.SenderBlockchainAddress,
+		Path:                       ot.Path,
+		RecipientBlockchainAddress: ot.RecipientBlockchainAddress,
+		Value:                      ot.Value,
+		SigningHash:                fmt.Sprintf("%x", ot.SigningHash),
+		Signed:                     ot.Signature != nil,
+	}
+}
 
-		transaction := NewTransaction(privateKey, publicKey,
-			*t.SenderBlockchainAddress, *t.RecipientBlockchainAddress, value32)
-		signature := tr.GenerateSignature(transaction)
-		signatureStr := signature.String()
+// senderPath is the derivation path of sender in keystore wallet kw: empty
+// for its only address unless it is an HD wallet, which may send from any
+// address it has derived or found by scanning.
+func senderPath(ws *entity.WalletServer, kr repository.KeystoreRepository, kw *entity.KeystoreWallet, sender string) (string, error) {
+	if kw.Type != KEYSTORE_TYPE_HD {
+		if sender != kw.BlockchainAddress {
+			return "", ErrUnknownSender
+		}
+		return "", nil
+	}
+	addresses, err := kr.Addresses(ws.Keystore, kw.ID)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range addresses {
+		if a.BlockchainAddress == sender {
+			return a.Path, nil
+		}
+	}
+	return "", ErrUnknownSender
+}
 
-		bt := &blockchainRequest.TransactionRequest{
-			SenderBlockchainAddress:    t.SenderBlockchainAddress,
-			RecipientBlockchainAddress: t.RecipientBlockchainAddress,
-			SenderPublicKey:            t.SenderPublicKey,
-			Value:                      &value32, Signature: &signatureStr,
+// UnsignedTransaction exports a payment for signing on another machine,
+// for instance with cmd/walletsign. Payments from an HD wallet carry the
+// sender's derivation path, so the signer finds its key even if it has
+// not derived that address itself.
+func (wsr walletServerRepository) UnsignedTransaction(ws *entity.WalletServer, kr repository.KeystoreRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		decoder := json.NewDecoder(req.Body)
+		var ur walletRequest.UnsignedTransactionRequest
+		err := decoder.Decode(&ur)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if !ur.Validate() {
+			log.Println("ERROR: missing field(s)")
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		value, err := strconv.ParseFloat(*ur.Value, 32)
+		if err != nil || value <= 0 {
+			log.Println("ERROR: parse error")
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		path := ""
+		if ur.WalletID != nil {
+			kw, err := kr.Get(ws.Keystore, *ur.WalletID)
+			if err == nil {
+				if ur.SenderBlockchainAddress == nil {
+					ur.SenderBlockchainAddress = &kw.BlockchainAddress
+				}
+				path, err = senderPath(ws, kr, kw, *ur.SenderBlockchainAddress)
+			}
+			if err != nil {
+				log.Printf("ERROR: %v", err)
+				w.WriteHeader(keystoreErrorStatus(err))
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
 		}
-		m, _ := json.Marshal(bt)
-		buf := bytes.NewBuffer(m)
+		ot := NewOfflineTransaction(*ur.SenderBlockchainAddress, *ur.RecipientBlockchainAddress, float32(value))
+		ot.Path = path
+		m, _ := json.Marshal(offlineTransactionResponse(ot))
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
 
-		resp, _ := http.Post(wsr.Gateway(ws)+"/transactions", "application/json", buf)
-		if resp.StatusCode == 201 {
-			io.WriteString(w, string(utils.JsonStatus("success")))
+// BroadcastTransaction checks a transaction signed offline and sends it to
+// the gateway. No key is involved on this side.
+func (wsr walletServerRepository) BroadcastTransaction(ws *entity.WalletServer, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		decoder := json.NewDecoder(req.Body)
+		var br walletRequest.BroadcastTransactionRequest
+		err := decoder.Decode(&br)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if !br.Validate() {
+			log.Println("ERROR: missing field(s)")
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		ot, err := DecodeOfflineTransaction(*br.Transaction)
+		if err == nil && ot.Signature == nil {
+			err = fmt.Errorf("offline transaction: not signed")
+		}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
 			return
 		}
-		io.WriteString(w, string(utils.JsonStatus("fail")))
+		t := NewTransaction(nil, ot.SenderPublicKey, ot.SenderBlockchainAddress, ot.RecipientBlockchainAddress, ot.Value)
+		if err := wsr.postTransaction(ws, t, ot.Signature); err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadGateway)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(utils.JsonStatus("success")))
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		log.Println("ERROR: Invalid HTTP Method")
 	}
 }
 
+// amount asks the gateway for the balance of blockchainAddress.
+func (wsr walletServerRepository) amount(ws *entity.WalletServer, blockchainAddress string) (float32, error) {
+	endpoint := fmt.Sprintf("%s/amount", wsr.Gateway(ws))
+
+	client := wsr.gatewayClient(ws)
+	bcsReq, _ := http.NewRequest("GET", endpoint, nil)
+	q := bcsReq.URL.Query()
+	q.Add("blockchain_address", blockchainAddress)
+	bcsReq.URL.RawQuery = q.Encode()
+
+	bcsResp, err := client.Do(bcsReq)
+	if err != nil {
+		return 0, err
+	}
+	defer bcsResp.Body.Close()
+	if bcsResp.StatusCode != 200 {
+		return 0, fmt.Errorf("gateway answered %s", bcsResp.Status)
+	}
+	decoder := json.NewDecoder(bcsResp.Body)
+	var bar response.AmountResponse
+	if err := decoder.Decode(&bar); err != nil {
+		return 0, err
+	}
+	return bar.Amount, nil
+}
+
 func (wsr walletServerRepository) WalletAmount(ws *entity.WalletServer, w http.ResponseWriter, req *http.Request) {
 	switch req.Method {
 	case http.MethodGet:
 		blockchainAddress := req.URL.Query().Get("blockchain_address")
-		endpoint := fmt.Sprintf("%s/amount", wsr.Gateway(ws))
+		amount, err := wsr.amount(ws, blockchainAddress)
+
+		w.Header().Add("Content-Type", "application/json")
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		m, _ := json.Marshal(struct {
+			Message string  `json:"message"`
+			Amount  float32 `json:"amount"`
+		}{
+			Message: "success",
+			Amount:  amount,
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// history asks the gateway's address index for one page of the transfers
+// of blockchainAddress.
+func (wsr walletServerRepository) history(ws *entity.WalletServer, blockchainAddress string, offset int, limit int) (*response.HistoryResponse, error) {
+	bcsReq, _ := http.NewRequest("GET", wsr.Gateway(ws)+"/history", nil)
+	q := bcsReq.URL.Query()
+	q.Add("blockchain_address", blockchainAddress)
+	q.Add("offset", strconv.Itoa(offset))
+	if limit > 0 {
+		q.Add("limit", strconv.Itoa(limit))
+	}
+	bcsReq.URL.RawQuery = q.Encode()
+
+	bcsResp, err := wsr.gatewayClient(ws).Do(bcsReq)
+	if err != nil {
+		return nil, err
+	}
+	defer bcsResp.Body.Close()
+	if bcsResp.StatusCode != 200 {
+		return nil, fmt.Errorf("gateway answered %s", bcsResp.Status)
+	}
+	var hr response.HistoryResponse
+	if err := json.NewDecoder(bcsResp.Body).Decode(&hr); err != nil {
+		return nil, err
+	}
+	return &hr, nil
+}
+
+func transferResponses(transfers []*response.TransferResponse) []*walletResponse.TransferResponse {
+	trs := make([]*walletResponse.TransferResponse, 0, len(transfers))
+	for _, t := range transfers {
+		trs = append(trs, &walletResponse.TransferResponse{
+			ID:            t.ID,
+			Direction:     t.Direction,
+			Counterparty:  t.Counterparty,
+			Amount:        t.Amount,
+			Height:        t.Height,
+			Timestamp:     t.Timestamp,
+			Confirmations: t.Confirmations,
+			Pending:       t.Pending,
+		})
+	}
+	return trs
+}
+
+// WalletHistory pages through the transfers of blockchain_address with
+// offset and limit, or exports all of them as CSV with format=csv.
+func (wsr walletServerRepository) WalletHistory(ws *entity.WalletServer, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		q := req.URL.Query()
+		blockchainAddress := q.Get("blockchain_address")
+		offset, err1 := strconv.Atoi(q.Get("offset"))
+		limit, err2 := strconv.Atoi(q.Get("limit"))
+		if blockchainAddress == "" || (q.Get("offset") != "" && err1 != nil) || (q.Get("limit") != "" && err2 != nil) {
+			log.Println("ERROR: invalid history query")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if q.Get("format") == "csv" {
+			wsr.historyCSV(ws, blockchainAddress, w)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		hr, err := wsr.history(ws, blockchainAddress, offset, limit)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadGateway)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		m, _ := json.Marshal(&walletResponse.HistoryResponse{
+			BlockchainAddress: hr.BlockchainAddress,
+			Transfers:         transferResponses(hr.Transfers),
+			Pending:           transferResponses(hr.Pending),
+			Offset:            hr.Offset,
+			Limit:             hr.Limit,
+			Total:             hr.Total,
+			Height:            hr.Height,
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		log.Printf("ERROR: Invalid HTTP Method")
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// historyCSV writes every transfer of blockchainAddress, pending ones
+// first. Blocks mined while it pages shift the offsets, so transfers seen
+// on an earlier page are skipped.
+func (wsr walletServerRepository) historyCSV(ws *entity.WalletServer, blockchainAddress string, w http.ResponseWriter) {
+	rows := [][]string{{"id", "direction", "counterparty", "amount", "height", "time", "confirmations", "pending"}}
+	seen := make(map[string]bool)
+	row := func(t *response.TransferResponse) {
+		if seen[t.ID+t.Direction] {
+			return
+		}
+		seen[t.ID+t.Direction] = true
+		height, timestamp := "", ""
+		if !t.Pending {
+			height = strconv.Itoa(t.Height)
+			timestamp = time.Unix(0, t.Timestamp).UTC().Format(time.RFC3339)
+		}
+		rows = append(rows, []string{
+			t.ID, t.Direction, t.Counterparty, strconv.FormatFloat(float64(t.Amount), 'f', -1, 32),
+			height, timestamp, strconv.Itoa(t.Confirmations), strconv.FormatBool(t.Pending),
+		})
+	}
+	for offset := 0; ; {
+		hr, err := wsr.history(ws, blockchainAddress, offset, HISTORY_EXPORT_PAGE_SIZE)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.Header().Add("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if offset == 0 {
+			for _, t := range hr.Pending {
+				row(t)
+			}
+		}
+		for _, t := range hr.Transfers {
+			row(t)
+		}
+		offset += hr.Limit
+		if offset >= hr.Total || len(hr.Transfers) == 0 {
+			break
+		}
+	}
+
+	w.Header().Add("Content-Type", "text/csv")
+	w.Header().Add("Content-Disposition", fmt.Sprintf("attachment; filename=\"history_%s.csv\"", blockchainAddress))
+	cw := csv.NewWriter(w)
+	cw.WriteAll(rows)
+}
 
-		client := &http.Client{}
-		bcsReq, _ := http.NewRequest("GET", endpoint, nil)
-		q := bcsReq.URL.Query()
-		q.Add("blockchain_address", blockchainAddress)
-		bcsReq.URL.RawQuery = q.Encode()
+func keystoreWalletResponse(kw *entity.KeystoreWallet) *walletResponse.KeystoreWalletResponse {
+	return &walletResponse.KeystoreWalletResponse{
+		ID:                kw.ID,
+		Name:              kw.Name,
+		Type:              kw.Type,
+		Path:              kw.Path,
+		BlockchainAddress: kw.BlockchainAddress,
+		PublicKey:         kw.PublicKey,
+		CreatedAt:         kw.CreatedAt,
+		Unlocked:          kw.UnlockedUntil != 0,
+		UnlockedUntil:     kw.UnlockedUntil,
+	}
+}
 
-		bcsResp, err := client.Do(bcsReq)
+// Wallets lists the keystore on GET and creates a wallet on POST. Neither
+// ever returns a private key.
+func (wsr walletServerRepository) Wallets(ws *entity.WalletServer, kr repository.KeystoreRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		wallets, err := kr.List(ws.Keystore)
 		if err != nil {
 			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
 			io.WriteString(w, string(utils.JsonStatus("fail")))
 			return
 		}
+		krs := make([]*walletResponse.KeystoreWalletResponse, 0, len(wallets))
+		for _, kw := range wallets {
+			krs = append(krs, keystoreWalletResponse(kw))
+		}
+		m, _ := json.Marshal(&walletResponse.KeystoreWalletsResponse{Wallets: krs, Length: len(krs)})
 
 		w.Header().Add("Content-Type", "application/json")
-		if bcsResp.StatusCode == 200 {
-			decoder := json.NewDecoder(bcsResp.Body)
-			var bar response.AmountResponse
-			err := decoder.Decode(&bar)
+		io.WriteString(w, string(m[:]))
+	case http.MethodPost:
+		decoder := json.NewDecoder(req.Body)
+		var cr walletRequest.CreateWalletRequest
+		err := decoder.Decode(&cr)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if !cr.Validate() {
+			log.Println("ERROR: missing field(s)")
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		var kw *entity.KeystoreWallet
+		mnemonic := ""
+		if (cr.HD != nil && *cr.HD) || cr.Mnemonic != nil {
+			restore := ""
+			if cr.Mnemonic != nil {
+				restore = *cr.Mnemonic
+			}
+			kw, mnemonic, err = kr.CreateHD(ws.Keystore, *cr.Name, *cr.Passphrase, restore)
+		} else {
+			kw, err = kr.Create(ws.Keystore, *cr.Name, *cr.Passphrase)
+		}
+		w.Header().Add("Content-Type", "application/json")
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		kwr := keystoreWalletResponse(kw)
+		kwr.Mnemonic = mnemonic
+		m, _ := json.Marshal(kwr)
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+func (wsr walletServerRepository) UnlockWallet(ws *entity.WalletServer, kr repository.KeystoreRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		decoder := json.NewDecoder(req.Body)
+		var ur walletRequest.UnlockWalletRequest
+		err := decoder.Decode(&ur)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if !ur.Validate() {
+			log.Println("ERROR: missing field(s)")
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		var timeout time.Duration
+		if ur.TimeoutSec != nil {
+			timeout = time.Duration(*ur.TimeoutSec) * time.Second
+		}
+		kw, token, err := kr.Unlock(ws.Keystore, *ur.ID, *ur.Passphrase, timeout)
+		w.Header().Add("Content-Type", "application/json")
+		switch err {
+		case nil:
+		case ErrWalletNotFound:
+			w.WriteHeader(http.StatusNotFound)
+		case ErrWrongPassphrase:
+			w.WriteHeader(http.StatusUnauthorized)
+		case ErrWatchOnly:
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		if err != nil {
+			log.Printf("ERROR: unlock %s: %v", *ur.ID, err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		kwr := keystoreWalletResponse(kw)
+		kwr.SessionToken = token
+		m, _ := json.Marshal(kwr)
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+// LockWallet succeeds for a wallet that is already locked.
+func (wsr walletServerRepository) LockWallet(ws *entity.WalletServer, kr repository.KeystoreRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		decoder := json.NewDecoder(req.Body)
+		var lr walletRequest.LockWalletRequest
+		err := decoder.Decode(&lr)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if !lr.Validate() {
+			log.Println("ERROR: missing field(s)")
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		kr.Lock(ws.Keystore, *lr.ID)
+
+		w.Header().Add("Content-Type", "application/json")
+		io.WriteString(w, string(utils.JsonStatus("success")))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+// WatchWallet adds a watch-only wallet for an address or public key.
+func (wsr walletServerRepository) WatchWallet(ws *entity.WalletServer, kr repository.KeystoreRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		decoder := json.NewDecoder(req.Body)
+		var wr walletRequest.WatchWalletRequest
+		err := decoder.Decode(&wr)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if !wr.Validate() {
+			log.Println("ERROR: missing field(s)")
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		blockchainAddress, publicKey := "", ""
+		if wr.BlockchainAddress != nil {
+			blockchainAddress = *wr.BlockchainAddress
+		}
+		if wr.PublicKey != nil {
+			publicKey = *wr.PublicKey
+		}
+		kw, err := kr.Watch(ws.Keystore, *wr.Name, blockchainAddress, publicKey)
+		w.Header().Add("Content-Type", "application/json")
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		m, _ := json.Marshal(keystoreWalletResponse(kw))
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+// summaryAddresses are the addresses WalletSummary adds up: every address
+// an HD wallet has derived or found by scanning, or the one address of
+// any other wallet.
+func summaryAddresses(ws *entity.WalletServer, kr repository.KeystoreRepository, kw *entity.KeystoreWallet) ([]string, error) {
+	if kw.Type != KEYSTORE_TYPE_HD {
+		return []string{kw.BlockchainAddress}, nil
+	}
+	addresses, err := kr.Addresses(ws.Keystore, kw.ID)
+	if err != nil {
+		return nil, err
+	}
+	blockchainAddresses := make([]string, 0, len(addresses))
+	for _, a := range addresses {
+		blockchainAddresses = append(blockchainAddresses, a.BlockchainAddress)
+	}
+	return blockchainAddresses, nil
+}
+
+// WalletSummary reports the balance, pending transfers and the latest
+// history of a keystore wallet, as the gateway sees them. For an HD wallet
+// they are added up over all of its addresses.
+func (wsr walletServerRepository) WalletSummary(ws *entity.WalletServer, kr repository.KeystoreRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		w.Header().Add("Content-Type", "application/json")
+		kw, err := kr.Get(ws.Keystore, req.URL.Query().Get("id"))
+		var addresses []string
+		if err == nil {
+			addresses, err = summaryAddresses(ws, kr, kw)
+		}
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(keystoreErrorStatus(err))
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		var amount float32
+		limit := 0
+		incoming := make([]*response.TransferResponse, 0)
+		outgoing := make([]*response.TransferResponse, 0)
+		history := make([]*response.TransferResponse, 0)
+		for _, blockchainAddress := range addresses {
+			a, err := wsr.amount(ws, blockchainAddress)
+			var hr *response.HistoryResponse
+			if err == nil {
+				hr, err = wsr.history(ws, blockchainAddress, 0, 0)
+			}
 			if err != nil {
 				log.Printf("ERROR: %v", err)
+				w.WriteHeader(http.StatusBadGateway)
 				io.WriteString(w, string(utils.JsonStatus("fail")))
 				return
 			}
+			amount += a
+			for _, t := range hr.Pending {
+				if t.Direction == "in" {
+					incoming = append(incoming, t)
+				} else {
+					outgoing = append(outgoing, t)
+				}
+			}
+			history = append(history, hr.Transfers...)
+			limit = hr.Limit
+		}
+		// Each address's page is newest first; so is their merge, cut to
+		// one page.
+		sort.SliceStable(history, func(i, j int) bool {
+			if history[i].Height != history[j].Height {
+				return history[i].Height > history[j].Height
+			}
+			return history[i].Timestamp > history[j].Timestamp
+		})
+		if limit > 0 && len(history) > limit {
+			history = history[:limit]
+		}
+		m, _ := json.Marshal(&walletResponse.WalletSummaryResponse{
+			Wallet:          keystoreWalletResponse(kw),
+			Amount:          amount,
+			PendingIncoming: transferResponses(incoming),
+			PendingOutgoing: transferResponses(outgoing),
+			History:         transferResponses(history),
+		})
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
 
-			m, _ := json.Marshal(struct {
-				Message string  `json:"message"`
-				Amount  float32 `json:"amount"`
-			}{
-				Message: "success",
-				Amount:  bar.Amount,
-			})
-			io.WriteString(w, string(m[:]))
-		} else {
+func hdAddressesResponse(addresses []*entity.HDAddress) *walletResponse.HDAddressesResponse {
+	ars := make([]*walletResponse.HDAddressResponse, 0, len(addresses))
+	var amount float32
+	for _, a := range addresses {
+		ars = append(ars, &walletResponse.HDAddressResponse{
+			Chain:             a.Chain,
+			Index:             a.Index,
+			Path:              a.Path,
+			BlockchainAddress: a.BlockchainAddress,
+			PublicKey:         a.PublicKey,
+			Used:              a.Used,
+			Amount:            a.Amount,
+		})
+		amount += a.Amount
+	}
+	return &walletResponse.HDAddressesResponse{Addresses: ars, Length: len(ars), Amount: amount}
+}
+
+func keystoreErrorStatus(err error) int {
+	switch err {
+	case ErrWalletNotFound:
+		return http.StatusNotFound
+	case ErrNotHD, ErrWatchOnly, ErrUnknownSender:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// WalletAddresses lists the derived addresses of an HD wallet on GET and
+// derives a fresh one on POST, so every payment can use a new address.
+func (wsr walletServerRepository) WalletAddresses(ws *entity.WalletServer, kr repository.KeystoreRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		addresses, err := kr.Addresses(ws.Keystore, req.URL.Query().Get("id"))
+		w.Header().Add("Content-Type", "application/json")
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(keystoreErrorStatus(err))
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		m, _ := json.Marshal(hdAddressesResponse(addresses))
+		io.WriteString(w, string(m[:]))
+	case http.MethodPost:
+		decoder := json.NewDecoder(req.Body)
+		var nr walletRequest.NewAddressRequest
+		err := decoder.Decode(&nr)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if !nr.Validate() {
+			log.Println("ERROR: missing field(s)")
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		chain := uint32(HD_RECEIVE)
+		if nr.Change != nil && *nr.Change {
+			chain = HD_CHANGE
+		}
+		a, err := kr.NewAddress(ws.Keystore, *nr.ID, chain)
+		w.Header().Add("Content-Type", "application/json")
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			w.WriteHeader(keystoreErrorStatus(err))
 			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
 		}
+		m, _ := json.Marshal(hdAddressesResponse([]*entity.HDAddress{a}).Addresses[0])
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, string(m[:]))
 	default:
-		log.Printf("ERROR: Invalid HTTP Method")
 		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
 	}
 }
 
-func (wsr walletServerRepository) Run(ws *entity.WalletServer, wr repository.WalletRepository, tr repository.TransactionRepository) {
+// ScanWallet looks for the used addresses of an HD wallet in the gateway's
+// address index, gap limit style, and reports their balances.
+func (wsr walletServerRepository) ScanWallet(ws *entity.WalletServer, kr repository.KeystoreRepository, w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		decoder := json.NewDecoder(req.Body)
+		var sr walletRequest.ScanWalletRequest
+		err := decoder.Decode(&sr)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		if !sr.Validate() {
+			log.Println("ERROR: missing field(s)")
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		gapLimit := HD_GAP_LIMIT
+		if sr.GapLimit != nil {
+			gapLimit = *sr.GapLimit
+		}
+		var gatewayErr error
+		used := func(blockchainAddress string) (bool, error) {
+			hr, err := wsr.history(ws, blockchainAddress, 0, 1)
+			if err != nil {
+				gatewayErr = err
+				return false, err
+			}
+			return hr.Total > 0 || len(hr.Pending) > 0, nil
+		}
+		addresses, err := kr.Scan(ws.Keystore, *sr.ID, used, gapLimit)
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			if err == gatewayErr {
+				w.WriteHeader(http.StatusBadGateway)
+			} else {
+				w.WriteHeader(keystoreErrorStatus(err))
+			}
+			io.WriteString(w, string(utils.JsonStatus("fail")))
+			return
+		}
+		for _, a := range addresses {
+			if !a.Used {
+				continue
+			}
+			if a.Amount, err = wsr.amount(ws, a.BlockchainAddress); err != nil {
+				log.Printf("ERROR: %v", err)
+				w.WriteHeader(http.StatusBadGateway)
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
+		}
+		m, _ := json.Marshal(hdAddressesResponse(addresses))
+		io.WriteString(w, string(m[:]))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("ERROR: Invalid HTTP Method")
+	}
+}
+
+// jsonOnly refuses POST requests whose body is not declared as JSON, so
+// a page on another site cannot submit an HTML form to the wallet server.
+func jsonOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPost {
+			mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				log.Printf("ERROR: %s sent as %q, not application/json", req.URL.Path, req.Header.Get("Content-Type"))
+				w.Header().Add("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				io.WriteString(w, string(utils.JsonStatus("fail")))
+				return
+			}
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (wsr walletServerRepository) Run(ws *entity.WalletServer, wr repository.WalletRepository, tr repository.TransactionRepository, kr repository.KeystoreRepository) {
 	http.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		wsr.Index(ws, w, req)
 	})
 	http.HandleFunc("/wallet", func(w http.ResponseWriter, req *http.Request) {
-		wsr.Wallet(wr, w, req)
+		wsr.Wallet(ws, wr, w, req)
+	})
+	http.HandleFunc("/wallets", func(w http.ResponseWriter, req *http.Request) {
+		wsr.Wallets(ws, kr, w, req)
+	})
+	http.HandleFunc("/wallets/unlock", func(w http.ResponseWriter, req *http.Request) {
+		wsr.UnlockWallet(ws, kr, w, req)
+	})
+	http.HandleFunc("/wallets/lock", func(w http.ResponseWriter, req *http.Request) {
+		wsr.LockWallet(ws, kr, w, req)
+	})
+	http.HandleFunc("/wallets/watch", func(w http.ResponseWriter, req *http.Request) {
+		wsr.WatchWallet(ws, kr, w, req)
+	})
+	http.HandleFunc("/wallets/summary", func(w http.ResponseWriter, req *http.Request) {
+		wsr.WalletSummary(ws, kr, w, req)
+	})
+	http.HandleFunc("/wallets/addresses", func(w http.ResponseWriter, req *http.Request) {
+		wsr.WalletAddresses(ws, kr, w, req)
+	})
+	http.HandleFunc("/wallets/scan", func(w http.ResponseWriter, req *http.Request) {
+		wsr.ScanWallet(ws, kr, w, req)
+	})
+	http.HandleFunc("/transaction/unsigned", func(w http.ResponseWriter, req *http.Request) {
+		wsr.UnsignedTransaction(ws, kr, w, req)
+	})
+	http.HandleFunc("/transaction/broadcast", func(w http.ResponseWriter, req *http.Request) {
+		wsr.BroadcastTransaction(ws, w, req)
+	})
+	http.HandleFunc("/wallet/history", func(w http.ResponseWriter, req *http.Request) {
+		wsr.WalletHistory(ws, w, req)
 	})
 	http.HandleFunc("/wallet/amount", func(w http.ResponseWriter, req *http.Request) {
 		wsr.WalletAmount(ws, w, req)
 	})
 	http.HandleFunc("/transaction", func(w http.ResponseWriter, req *http.Request) {
-		wsr.CreateTransaction(ws, tr, w, req)
+		wsr.CreateTransaction(ws, tr, kr, w, req)
 	})
-	log.Fatal(http.ListenAndServe("0.0.0.0:"+strconv.Itoa(int(wsr.Port(ws))), nil))
+	address := net.JoinHostPort(ws.Host, strconv.Itoa(int(wsr.Port(ws))))
+	handler := jsonOnly(http.DefaultServeMux)
+	if ws.TLSCertFile == "" {
+		log.Fatal(http.ListenAndServe(address, handler))
+	}
+	log.Fatal(http.ListenAndServeTLS(address, ws.TLSCertFile, ws.TLSKeyFile, handler))
 }
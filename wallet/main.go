@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"log"
+	"net"
 
 	"go-blockchain/wallet/infra/repository"
 )
@@ -15,11 +16,34 @@ func main() {
 	wsr := repository.NewWalletServerRepository()
 	wr := repository.NewWalletRepository()
 	tr := repository.NewTransactionRepository()
+	kr := repository.NewKeystoreRepository()
 
+	host := flag.String("host", "127.0.0.1", "Interface to listen on, empty for all; others can reach unlocked wallets")
 	port := flag.Uint("port", 8080, "TCP Port Number for Wallet Server")
 	gateway := flag.String("gateway", "http://127.0.0.1:5000", "Blockchain Gateway")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate; enables HTTPS")
+	tlsKey := flag.String("tls-key", "", "TLS private key")
+	gatewayCA := flag.String("gateway-ca", "", "CA bundle the HTTPS gateway certificate must be signed by")
+	walletDir := flag.String("wallet-dir", "wallets", "Directory of encrypted wallet files")
+	insecureRawKeys := flag.Bool("insecure-raw-keys", false, "Allow POST /wallet and sender_private_key, which send private keys over HTTP")
 	flag.Parse()
 
 	ws := repository.NewWalletServer(uint16(*port), *gateway)
-	wsr.Run(ws, wr, tr)
+	ws.Host = *host
+	if ip := net.ParseIP(*host); *host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		log.Printf("WARNING: listening on %q, not only on this machine", *host)
+	}
+	ws.TLSCertFile = *tlsCert
+	ws.TLSKeyFile = *tlsKey
+	ws.GatewayCAFile = *gatewayCA
+	ks, err := repository.NewKeystore(*walletDir)
+	if err != nil {
+		log.Fatalf("ERROR: %v", err)
+	}
+	ws.Keystore = ks
+	ws.InsecureRawKeys = *insecureRawKeys
+	if ws.InsecureRawKeys {
+		log.Println("WARNING: raw private keys are accepted over HTTP")
+	}
+	wsr.Run(ws, wr, tr, kr)
 }